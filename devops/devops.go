@@ -43,6 +43,8 @@ type Configuration struct {
 	ClusterName  string
 	EksAdminRole string
 	Platform     string
+	// Access entry of the CodeBuild role instead of aws-auth (AuthenticationMode API_AND_CONFIG_MAP or API in eks/config.json)
+	EksAccessEntry bool
 }
 
 func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
@@ -100,6 +102,25 @@ func NewDevopsStack(scope constructs.Construct, id string, props *DevopsStackPro
 	})
 	buildAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromAwsManagedPolicyName(aws.String("AmazonEKSClusterPolicy")))
 
+	// Give the CodeBuild role access to the cluster with an access entry
+	// (AWS::EKS::AccessEntry is not in the CDK version of the stack)
+	if AppConfig.EksAccessEntry {
+		awscdk.NewCfnResource(stack, jsii.String("BuildAccessEntry"), &awscdk.CfnResourceProps{
+			Type: jsii.String("AWS::EKS::AccessEntry"),
+			Properties: &map[string]interface{}{
+				"ClusterName":  AppConfig.ClusterName + AppConfig1.Index,
+				"PrincipalArn": buildAdminRole.RoleArn(),
+				"Type":         "STANDARD",
+				"AccessPolicies": []interface{}{
+					map[string]interface{}{
+						"PolicyArn":   fmt.Sprintf("arn:%s:eks::aws:cluster-access-policy/AmazonEKSClusterAdminPolicy", *stack.Partition()),
+						"AccessScope": map[string]interface{}{"Type": "cluster"},
+					},
+				},
+			},
+		})
+	}
+
 	// Create a CodeCommit repository
 	Repo := awscodecommit.NewRepository(stack, &StackRepoN, &awscodecommit.RepositoryProps{
 		RepositoryName: &RepoNameCd,
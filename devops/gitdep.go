@@ -13,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"CDK/pkg/awsauth"
 	"aws-cicd/pkg/mainconfig"
 
 	"gopkg.in/yaml.v2"
@@ -28,21 +29,12 @@ import (
 	gitconfig "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing/object"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
-	"k8s.io/client-go/util/retry"
 )
 
-const configMapYAML1 = `
-    - rolearn: %s
-      username: admin
-      groups:
-        - system:masters
-`
-
 type BuildSpec struct {
 	Version string `yaml:"version"`
 	Env     struct {
@@ -98,6 +90,10 @@ type Configuration struct {
 	ClusterName      string
 	EksAdminRole     string
 	SecondBramchName string
+	EksAccessEntry   bool
+	// Kubernetes username and groups of the CodeBuild role in aws-auth (admin, system:masters)
+	AwsAuthUsername string
+	AwsAuthGroups   []string
 }
 
 func readJSONConfig(filename string, config interface{}) {
@@ -120,31 +116,72 @@ func GetConfig(configcrd mainconfig.ConfAuth, configjs Configuration) (mainconfi
 	return configcrd, configjs
 }
 
-func updateAwsAuthConfigMap(clientset *kubernetes.Clientset, rolearn string) {
-	configMapName := "aws-auth"
-	namespace := "kube-system"
-	//namespace := "test"
-
-	configMapYAMLWithARN := fmt.Sprintf(configMapYAML1, rolearn)
+// awsAuthMapping returns the aws-auth mapping of the CodeBuild role : admin in system:masters by default
+func awsAuthMapping(AppConfig Configuration, rolearn string) awsauth.RoleMapping {
+	mapping := awsauth.RoleMapping{
+		RoleARN:  rolearn,
+		Username: AppConfig.AwsAuthUsername,
+		Groups:   AppConfig.AwsAuthGroups,
+	}
+	if mapping.Username == "" {
+		mapping.Username = "admin"
+	}
+	if len(mapping.Groups) == 0 {
+		mapping.Groups = []string{"system:masters"}
+	}
+	return mapping
+}
 
-	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
-		configMap, getErr := clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), configMapName, metav1.GetOptions{})
-		if getErr != nil {
-			return getErr
+// trustsPrincipal reports whether the trust policy of a role (URL encoded by IAM) allows the principal arn
+func trustsPrincipal(policyDocument string, arn string) (bool, error) {
+	document, err := url.QueryUnescape(policyDocument)
+	if err != nil {
+		return false, err
+	}
+	var policy struct {
+		Statement []struct {
+			Effect    string
+			Principal struct {
+				AWS interface{}
+			}
 		}
+	}
+	if err := json.Unmarshal([]byte(document), &policy); err != nil {
+		return false, err
+	}
+	for _, statement := range policy.Statement {
+		if statement.Effect != "Allow" {
+			continue
+		}
+		switch principals := statement.Principal.AWS.(type) {
+		case string:
+			if principals == arn {
+				return true, nil
+			}
+		case []interface{}:
+			for _, principal := range principals {
+				if principal == arn {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
 
-		// Get the current value of mapRoles
-		currentValue, _ := configMap.Data["mapRoles"]
-
-		configMap.Data["mapRoles"] = currentValue + "\n" + configMapYAMLWithARN
-
-		_, updateErr := clientset.CoreV1().ConfigMaps(namespace).Update(context.TODO(), configMap, metav1.UpdateOptions{})
-		return updateErr
-	})
+// kubeClientset returns the client of the cluster of the current kubeconfig context
+func kubeClientset() (*rest.Config, string, *kubernetes.Clientset) {
+	kubeconfigPath := filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	}
 
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		panic(err.Error())
+		glog.Fatalf("❌ Failed to create a ClientSet: %v. Exiting.", err)
 	}
+	return config, kubeconfigPath, clientset
 }
 
 func CheckIfError(err error) {
@@ -241,7 +278,7 @@ func main() {
 	codeCommitRepoURL := "codecommit://" + AppConfig1.SSOProfile + "@" + RepoNameCd
 	filePath := RepoNameCd + "/" + BuildFile
 
-	destroyFlag := flag.Bool("destroy", false, "Set to true to destroy the added statement in the trust policy")
+	destroyFlag := flag.Bool("destroy", false, "Set to true to remove the CodeBuild role from the aws-auth ConfigMap")
 
 	// Parse the command-line arguments
 	flag.Parse()
@@ -253,120 +290,48 @@ func main() {
 		Region: aws.String(AppConfig1.Region),
 	}))
 
-	// Create a IAM client
-	svc := iam.New(sess)
-
-	// Get Existing EKS Admin Role
-	getRoleInput := &iam.GetRoleInput{
-		RoleName: &AdmRole,
-	}
-	getRoleOutput, err := svc.GetRole(getRoleInput)
-	if err != nil {
-		fmt.Println("❌ Error getting EKS Admin Role:", err)
-		return
-	}
-
-	// URL decode the existing trust policy
-	existingPolicy, err := url.QueryUnescape(aws.StringValue(getRoleOutput.Role.AssumeRolePolicyDocument))
-	if err != nil {
-		fmt.Println("❌ Error URL decoding existing policy for EKS Admin Role:", err)
-		return
-	}
-
-	// Update the existing trust policy by appending the new statement
-	existingPolicyMap := make(map[string]interface{})
-	err = json.Unmarshal([]byte(existingPolicy), &existingPolicyMap)
-	if err != nil {
-		fmt.Println("❌ Error unmarshalling existing policy for EKS Admin Role :", err)
-		return
-	}
-
 	if *destroyFlag {
-		statements, ok := existingPolicyMap["Statement"].([]interface{})
-
-		if ok {
-			for i, statement := range statements {
-				statementMap, ok := statement.(map[string]interface{})
-				if ok && statementMap["Effect"] == "Allow" {
-					principal, ok := statementMap["Principal"].(map[string]interface{})
-					if ok && principal["AWS"] == buildAdminRoleARN {
-						//if ok && principal["AWS"] == "AROARP77TJVWGO7ZRDPSF" {
-						// Remove the statement from the list
-						existingPolicyMap["Statement"] = append(statements[:i], statements[i+1:]...)
-						updatedPolicyJSON, err := json.Marshal(existingPolicyMap)
-						if err != nil {
-							fmt.Println("❌ Error marshalling updated policy for EKS Admin Role:", err)
-							return
-						}
-
-						// Update the role's trust policy
-						updateAssumeRolePolicyInput := &iam.UpdateAssumeRolePolicyInput{
-							RoleName:       &AdmRole,
-							PolicyDocument: aws.String(string(updatedPolicyJSON)),
-						}
-
-						_, err = svc.UpdateAssumeRolePolicy(updateAssumeRolePolicyInput)
-						if err != nil {
-							fmt.Println("❌ Error updating EKS Admin Role trust policy:", err)
-							return
-						}
-
-						break
-					}
-				}
+		// Remove the mapping of the CodeBuild role added at the deployment
+		if !AppConfig.EksAccessEntry {
+			_, _, clientset := kubeClientset()
+			if err := awsauth.RemoveRole(context.TODO(), clientset, buildAdminRoleARN); err != nil {
+				fmt.Println("❌ Error updating aws-auth ConfigMap:", err)
+				os.Exit(1)
 			}
+			fmt.Println("✅ CodeBuild role removed from aws-auth ConfigMap.")
 		}
-		fmt.Println("✅ Update the existing trust policy on EKS Cluster")
 
 	} else {
 
 		// wait CodeCommit repo created
 		waitForCodeCommitCreation(RepoNameCd)
 
-		newStatement := map[string]interface{}{
-			"Effect": "Allow",
-			"Principal": map[string]interface{}{
-				"AWS": buildAdminRoleARN,
-			},
-			"Action": "sts:AssumeRole",
-		}
-
-		existingPolicyMap["Statement"] = append(existingPolicyMap["Statement"].([]interface{}), newStatement)
-
-		updatedPolicyJSON, err := json.Marshal(existingPolicyMap)
+		// The admin role of the EKS stack is assumed by the builds : it trusts the CodeBuild role
+		// listed in AdminPrincipals (eks/config.json), its trust policy is managed by the EKS stack
+		svc := iam.New(sess)
+		getRoleOutput, err := svc.GetRole(&iam.GetRoleInput{
+			RoleName: &AdmRole,
+		})
 		if err != nil {
-			fmt.Println("❌ Error marshalling updated policy for EKS Admin Role:", err)
-			return
-		}
-
-		// Update the role's trust policy
-		updateAssumeRolePolicyInput := &iam.UpdateAssumeRolePolicyInput{
-			RoleName:       &AdmRole,
-			PolicyDocument: aws.String(string(updatedPolicyJSON)),
+			fmt.Println("❌ Error getting EKS Admin Role:", err)
+			os.Exit(1)
 		}
-
-		_, err = svc.UpdateAssumeRolePolicy(updateAssumeRolePolicyInput)
+		trusted, err := trustsPrincipal(aws.StringValue(getRoleOutput.Role.AssumeRolePolicyDocument), buildAdminRoleARN)
 		if err != nil {
-			fmt.Println("❌ Error updating EKS Admin Role trust policy:", err)
-			return
+			fmt.Println("❌ Error reading the trust policy of EKS Admin Role:", err)
+			os.Exit(1)
 		}
-
-		fmt.Println("✅ Successfully updated EKS Admin Role.")
+		if !trusted {
+			fmt.Printf("❌ EKS Admin Role %s doesn't trust %s : add it to AdminPrincipals in eks/config.json and deploy the EKS stack\n", AdmRole, buildAdminRoleARN)
+			os.Exit(1)
+		}
+		fmt.Println("✅ EKS Admin Role trusts the CodeBuild role.")
 
 		// Create a CloudFormation client
 		cfClient := cloudformation.New(sess)
 
 		// Load Kubeconfig
-		kubeconfigPath := filepath.Join(os.Getenv("HOME"), ".kube", "config")
-		config, err := rest.InClusterConfig()
-		if err != nil {
-			config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-		}
-
-		clientset, err := kubernetes.NewForConfig(config)
-		if err != nil {
-			glog.Fatalf("❌ Failed to create a ClientSet: %v. Exiting.", err)
-		}
+		config, kubeconfigPath, clientset := kubeClientset()
 
 		// Get the current context's cluster name
 		EKSClusterName, err := getCurrentClusterName(config, kubeconfigPath)
@@ -397,9 +362,19 @@ func main() {
 			roleArn = *output.OutputValue
 		}
 
-		updateAwsAuthConfigMap(clientset, roleArn)
-		spin1.Stop()
-		fmt.Println("✅ Successfully updated aws-auth ConfigMap.")
+		// With an access entry (devops stack), aws-auth is left unchanged
+		if AppConfig.EksAccessEntry {
+			spin1.Stop()
+			fmt.Println("✅ CodeBuild role access by access entry, aws-auth ConfigMap unchanged.")
+		} else {
+			if err := awsauth.AddRole(context.TODO(), clientset, awsAuthMapping(AppConfig, roleArn)); err != nil {
+				spin1.Stop()
+				fmt.Println("❌ Error updating aws-auth ConfigMap:", err)
+				os.Exit(1)
+			}
+			spin1.Stop()
+			fmt.Println("✅ Successfully updated aws-auth ConfigMap.")
+		}
 
 		spin1.Suffix = " Clone GitHub App Java Demo ..."
 		spin1.Start()
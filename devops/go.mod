@@ -3,6 +3,7 @@ module devops
 go 1.21.1
 
 require (
+	CDK/pkg/awsauth v1.0.0
 	github.com/aws/aws-cdk-go/awscdk/v2 v2.110.1
 	github.com/aws/aws-sdk-go v1.47.9
 	github.com/aws/constructs-go/constructs/v10 v10.3.0
@@ -92,3 +93,5 @@ require (
 )
 
 replace aws-cicd/pkg/mainconfig v1.0.0 => ../pkg/mainconfig
+
+replace CDK/pkg/awsauth v1.0.0 => ../pkg/awsauth
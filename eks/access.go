@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/customresources"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// AccessEntry gives an IAM principal access to the cluster with an EKS access policy
+type AccessEntry struct {
+	PrincipalArn     string
+	Type             string   // STANDARD (default) or EC2_LINUX for the nodes (no policy)
+	Policy           string   // AmazonEKSClusterAdminPolicy (default), AmazonEKSAdminPolicy, AmazonEKSEditPolicy or AmazonEKSViewPolicy
+	Namespaces       []string // scope of the policy, the whole cluster if empty
+	KubernetesGroups []string
+	Username         string
+}
+
+// authenticationModes are the authentication modes of the cluster : CONFIG_MAP is the aws-auth
+// ConfigMap only (default), the access entries need API_AND_CONFIG_MAP or API
+var authenticationModes = map[string]bool{
+	"CONFIG_MAP":         true,
+	"API_AND_CONFIG_MAP": true,
+	"API":                true,
+}
+
+// accessPolicies are the access policies of the access entries
+var accessPolicies = map[string]bool{
+	"AmazonEKSClusterAdminPolicy": true,
+	"AmazonEKSAdminPolicy":        true,
+	"AmazonEKSEditPolicy":         true,
+	"AmazonEKSViewPolicy":         true,
+}
+
+// accessEntriesEnabled reports whether the cluster uses the access entries, validating AuthenticationMode
+func accessEntriesEnabled(AppConfig Configuration) (bool, error) {
+	if AppConfig.AuthenticationMode == "" {
+		return false, nil
+	}
+	if !authenticationModes[AppConfig.AuthenticationMode] {
+		return false, fmt.Errorf("unknown AuthenticationMode %q : CONFIG_MAP, API_AND_CONFIG_MAP or API", AppConfig.AuthenticationMode)
+	}
+	return AppConfig.AuthenticationMode != "CONFIG_MAP", nil
+}
+
+// setAuthenticationMode sets the authentication mode of the cluster with UpdateClusterConfig. The
+// resource is always in the template : CloudFormation only calls it again when the mode changes,
+// and EKS rejects a mode with less access entries (API -> API_AND_CONFIG_MAP). The cluster is
+// created in CONFIG_MAP, the cluster resource of CDK doesn't know accessConfig yet
+func setAuthenticationMode(stack awscdk.Stack, cluster awseks.Cluster, clusterName string, mode string) customresources.AwsCustomResource {
+	call := &customresources.AwsSdkCall{
+		Service: jsii.String("EKS"),
+		Action:  jsii.String("updateClusterConfig"),
+		Parameters: map[string]interface{}{
+			"name":         clusterName,
+			"accessConfig": map[string]interface{}{"authenticationMode": mode},
+		},
+		PhysicalResourceId: customresources.PhysicalResourceId_Of(jsii.String(clusterName + "-authentication-mode")),
+	}
+	update := customresources.NewAwsCustomResource(stack, jsii.String("AuthenticationMode"), &customresources.AwsCustomResourceProps{
+		OnCreate:            call,
+		OnUpdate:            call,
+		InstallLatestAwsSdk: jsii.Bool(true),
+		Policy: customresources.AwsCustomResourcePolicy_FromSdkCalls(&customresources.SdkCallsPolicyOptions{
+			Resources: &[]*string{cluster.ClusterArn()},
+		}),
+	})
+	update.Node().AddDependency(cluster)
+	return update
+}
+
+// addAccessEntry creates the access entry of a principal with its access policy
+// (AWS::EKS::AccessEntry is not in the CDK version of the stack)
+func addAccessEntry(stack awscdk.Stack, id string, clusterName string, entry AccessEntry) (awscdk.CfnResource, error) {
+	if entry.PrincipalArn == "" {
+		return nil, fmt.Errorf("access entry %s : PrincipalArn is required", id)
+	}
+	switch entry.Type {
+	case "", "STANDARD":
+	case "EC2_LINUX":
+		return awscdk.NewCfnResource(stack, jsii.String(id), &awscdk.CfnResourceProps{
+			Type: jsii.String("AWS::EKS::AccessEntry"),
+			Properties: &map[string]interface{}{
+				"ClusterName":  clusterName,
+				"PrincipalArn": entry.PrincipalArn,
+				"Type":         entry.Type,
+			},
+		}), nil
+	default:
+		return nil, fmt.Errorf("access entry %s : unknown Type %q : STANDARD or EC2_LINUX", id, entry.Type)
+	}
+	policy := entry.Policy
+	if policy == "" {
+		policy = "AmazonEKSClusterAdminPolicy"
+	}
+	if !accessPolicies[policy] {
+		return nil, fmt.Errorf("access entry %s : unknown Policy %q", id, entry.Policy)
+	}
+
+	scope := map[string]interface{}{"Type": "cluster"}
+	if len(entry.Namespaces) > 0 {
+		scope = map[string]interface{}{"Type": "namespace", "Namespaces": entry.Namespaces}
+	}
+	properties := map[string]interface{}{
+		"ClusterName":  clusterName,
+		"PrincipalArn": entry.PrincipalArn,
+		"Type":         "STANDARD",
+		"AccessPolicies": []interface{}{
+			map[string]interface{}{
+				"PolicyArn":   fmt.Sprintf("arn:%s:eks::aws:cluster-access-policy/%s", *stack.Partition(), policy),
+				"AccessScope": scope,
+			},
+		},
+	}
+	if len(entry.KubernetesGroups) > 0 {
+		properties["KubernetesGroups"] = entry.KubernetesGroups
+	}
+	if entry.Username != "" {
+		properties["Username"] = entry.Username
+	}
+
+	return awscdk.NewCfnResource(stack, jsii.String(id), &awscdk.CfnResourceProps{
+		Type:       jsii.String("AWS::EKS::AccessEntry"),
+		Properties: &properties,
+	}), nil
+}
+
+// addAccessEntries enables the access entries of the cluster and creates the entries of the admin
+// role and of the AccessEntries of the configuration, after the update of the authentication mode
+// (returned for the access entries created elsewhere)
+func addAccessEntries(stack awscdk.Stack, cluster awseks.Cluster, clusterName string, adminRoleArn *string, AppConfig Configuration) (constructs.IDependable, error) {
+	authentication := setAuthenticationMode(stack, cluster, clusterName, AppConfig.AuthenticationMode)
+
+	entries := []AccessEntry{{PrincipalArn: *adminRoleArn}}
+	entries = append(entries, AppConfig.AccessEntries...)
+	for i, entry := range entries {
+		id := "AdminAccessEntry"
+		if i > 0 {
+			id = fmt.Sprintf("AccessEntry%d", i)
+		}
+		accessEntry, err := addAccessEntry(stack, id, clusterName, entry)
+		if err != nil {
+			return nil, err
+		}
+		accessEntry.Node().AddDependency(authentication)
+	}
+	return authentication, nil
+}
@@ -9,19 +9,20 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
-	"time"
+
+	"CDK/pkg/manifest"
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
-	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
-	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsssm"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 
 	"github.com/golang/glog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -61,19 +62,19 @@ type Configuration struct {
 	AddonVersion string
 	ScName       string
 	ScNamef      string
+	// Kustomize overlay by Index when ScNamef is a kustomization directory
+	Overlays map[string]string
+	// Autoscaler of the nodes : "" (none), karpenter (EKS stack) or cluster-autoscaler
+	Autoscaler        string
+	ClusterAutoscaler ClusterAutoscaler
+	// Container Insights and the logs of the nodes and pods in CloudWatch
+	CloudWatchObservability CloudWatchObservability
+	// Managed add-ons, the EBS CSI driver in AddonVersion if empty
+	Addons []Addon
 }
 
-type ClusterProps struct {
-	stack       awscdk.Stack
-	clusterName string
-	region      string
-}
-
-type EksClusterWithOIDC struct {
-	OidcIssuer string
-}
-
-func applyResourcesFromYAML(yamlContent []byte, clientset *kubernetes.Clientset, dd *dynamic.DynamicClient) error {
+// forEachResourceFromYAML decodes the resources of a manifest and calls fn with their dynamic client
+func forEachResourceFromYAML(yamlContent []byte, clientset *kubernetes.Clientset, dd *dynamic.DynamicClient, fn func(dynamic.ResourceInterface, *unstructured.Unstructured) error) error {
 	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(yamlContent), 100)
 
 	for {
@@ -118,43 +119,41 @@ func applyResourcesFromYAML(yamlContent []byte, clientset *kubernetes.Clientset,
 			dri = dd.Resource(mapping.Resource)
 		}
 
-		_, err = dri.Create(context.Background(), unstructuredObj, metav1.CreateOptions{})
-		if err != nil {
+		if err := fn(dri, unstructuredObj); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func EksClusterInfo(scope constructs.Construct, id *string, props *ClusterProps) *EksClusterWithOIDC {
-
-	sess := session.Must(session.NewSession())
-	svc := eks.New(sess, aws.NewConfig().WithRegion(props.region))
+func applyResourcesFromYAML(yamlContent []byte, clientset *kubernetes.Clientset, dd *dynamic.DynamicClient) error {
+	return forEachResourceFromYAML(yamlContent, clientset, dd, func(dri dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
+		_, err := dri.Create(context.Background(), obj, metav1.CreateOptions{})
+		return err
+	})
+}
 
-	for {
-		input := &eks.DescribeClusterInput{
-			Name: aws.String(props.clusterName),
-		}
-		result, err := svc.DescribeCluster(input)
-		if err != nil {
-			fmt.Println("❌ Error describing EKS cluster:", err)
-			return nil
-		}
+// fieldManager owns the fields of the resources applied by the stack
+const fieldManager = "eksstackconfig"
 
-		status := aws.StringValue(result.Cluster.Status)
+// serverSideApplyFromYAML applies the resources of a manifest with server-side apply : created on
+// the first deployment, updated with the manifest on the next ones (image of the K8sVersion)
+func serverSideApplyFromYAML(yamlContent []byte, clientset *kubernetes.Clientset, dd *dynamic.DynamicClient) error {
+	return forEachResourceFromYAML(yamlContent, clientset, dd, func(dri dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
+		_, err := dri.Apply(context.Background(), obj.GetName(), obj, metav1.ApplyOptions{FieldManager: fieldManager, Force: true})
+		return err
+	})
+}
 
-		if status == "ACTIVE" {
-			fmt.Println("EKS Cluster is now active.")
-			oidcIssuer := aws.StringValue(result.Cluster.Identity.Oidc.Issuer)
-			return &EksClusterWithOIDC{
-				OidcIssuer: oidcIssuer,
-			}
-		} else {
-			fmt.Println("Cluster status:", status)
+// deleteResourcesFromYAML deletes the resources of a manifest, the resources already deleted are skipped
+func deleteResourcesFromYAML(yamlContent []byte, clientset *kubernetes.Clientset, dd *dynamic.DynamicClient) error {
+	return forEachResourceFromYAML(yamlContent, clientset, dd, func(dri dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
+		err := dri.Delete(context.Background(), obj.GetName(), metav1.DeleteOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
 		}
-
-		time.Sleep(30 * time.Second)
-	}
+		return err
+	})
 }
 
 func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
@@ -181,6 +180,11 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	return configcrd, configjs
 }
 
+// eksParameterPrefix is the SSM path of the parameters written by the EKS stack by Index
+func eksParameterPrefix(index string) string {
+	return "/aws-cicd/" + index + "/eks/"
+}
+
 func NewEksstackconfigStack(scope constructs.Construct, id string, props *EksstackconfigStackProps, AppConfig Configuration, AppConfig1 ConfAuth, destroy string) awscdk.Stack {
 	var sprops awscdk.StackProps
 	if props != nil {
@@ -190,21 +194,12 @@ func NewEksstackconfigStack(scope constructs.Construct, id string, props *Ekssta
 
 	// Set Variables
 	var clusterName = AppConfig.ClusterName + AppConfig1.Index
-	var EbsRole = clusterName + AppConfig.EBSRole
-
-	var policyArn = "arn:aws:iam::aws:policy/service-role/AmazonEBSCSIDriverPolicy"
+	var AutoscalerRole = clusterName + "ClusterAutoscalerRole"
 
-	eksClusterProps := ClusterProps{
-		clusterName: clusterName,
-		region:      AppConfig1.Region,
-	}
-
-	InfosEks := EksClusterInfo(stack, jsii.String("EKSInfo"), &eksClusterProps)
-
-	oidcIssuer := InfosEks.OidcIssuer
-	parts := strings.Split(oidcIssuer, "/")
-	// Get the last part (element) from the slice
-	OpenID := parts[len(parts)-1]
+	// OIDC issuer published by the EKS stack of the same Index (cached in cdk.context.json)
+	oidcIssuer := *awsssm.StringParameter_ValueFromLookup(stack, jsii.String(eksParameterPrefix(AppConfig1.Index)+"oidc-issuer"))
+	// Trusted by the IRSA roles of the add-ons and Cluster Autoscaler
+	provider := clusterOIDCProvider(stack, oidcIssuer)
 
 	/*------------------------------ Connect K8s ---------------------------------------------*/
 	// Load Kubeconfig
@@ -227,90 +222,47 @@ func NewEksstackconfigStack(scope constructs.Construct, id string, props *Ekssta
 
 	/*---------------------------End Connect K8s ---------------------------------------------*/
 
-	/*--------------------------- Change Role Label EKS Node ---------------------------------*/
-	if destroy == "false" {
-		// List all nodes in the cluster
-		nodes, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		// Label each node with the desired label
-		for _, node := range nodes.Items {
-			nodeName := node.ObjectMeta.Name
-			labels := node.ObjectMeta.Labels
-			if labels == nil {
-				labels = make(map[string]string)
-			}
-
-			// Add or update the label "node-role.kubernetes.io/worker" to "worker"
-			labels["node-role.kubernetes.io/worker"] = "worker"
-
-			node.ObjectMeta.Labels = labels
-			_, err = clientset.CoreV1().Nodes().Update(context.Background(), &node, metav1.UpdateOptions{})
-			if err != nil {
-				log.Printf("❌ Failed to label node %s: %v", nodeName, err)
-
-			} else {
-				log.Printf("✅ Successfully labeled node %s", nodeName)
-
-			}
+	// Managed add-ons with the IRSA roles of their service accounts
+	Addons, err := resolveAddons(AppConfig)
+	if err != nil {
+		fmt.Println("❌ Error in the add-ons configuration:", err)
+		os.Exit(1)
+	}
+	var eksSvc eksiface.EKSAPI
+	eksClient := func() eksiface.EKSAPI {
+		if eksSvc == nil {
+			sess := session.Must(session.NewSession(&aws.Config{
+				Region: aws.String(AppConfig1.Region),
+			}))
+			eksSvc = eks.New(sess)
 		}
+		return eksSvc
+	}
+	if err := addAddons(stack, eksClient, AppConfig, Addons, clusterName, provider); err != nil {
+		fmt.Println("❌ Error in the add-ons configuration:", err)
+		os.Exit(1)
 	}
-	/*--------------------------- Change Role Label EKS Node ---------------------------------*/
-
-	/*--------------------------- Created a Role for EBS CSI Storage ------------------------*/
-
-	//Set Federated, Auth and Sub Trust Relationships For Role at CSI Drivers
-	Fed := fmt.Sprintf("%s%s%s%s%s%s", "arn:aws:iam::", AppConfig1.Account, ":oidc-provider/oidc.eks.", AppConfig1.Region, ".amazonaws.com/id/", OpenID)
-	Aud := fmt.Sprintf("%s%s%s%s%s", "oidc.eks.", AppConfig1.Region, ".amazonaws.com/id/", OpenID, ":aud")
-	Sub := fmt.Sprintf("%s%s%s%s%s", "oidc.eks.", AppConfig1.Region, ".amazonaws.com/id/", OpenID, ":sub")
-
-	// Create a PolicyDocument for the AssumeRolePolicyDocument for CSI Role
-	assumeRolePolicy := awsiam.NewPolicyDocument(&awsiam.PolicyDocumentProps{
-		Statements: &[]awsiam.PolicyStatement{
-			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
-				Effect:  awsiam.Effect_ALLOW,
-				Actions: &[]*string{jsii.String("sts:AssumeRoleWithWebIdentity")},
-				Principals: &[]awsiam.IPrincipal{
-					awsiam.NewFederatedPrincipal(&Fed, nil, nil),
-				},
-				Conditions: &map[string]interface{}{
-					"StringEquals": map[string]interface{}{
-						Aud: "sts.amazonaws.com",
-						Sub: "system:serviceaccount:kube-system:ebs-csi-controller-sa",
-					},
-				},
-			}),
-		},
-	})
-
-	// Create a CfnRole with the AssumeRolePolicyDocument
-	cfnRole := awsiam.NewCfnRole(stack, &EbsRole, &awsiam.CfnRoleProps{
-		AssumeRolePolicyDocument: assumeRolePolicy,
-		RoleName:                 &EbsRole,
-		ManagedPolicyArns: &[]*string{
-			&policyArn,
-		},
-	})
-
-	/*--------------------- End Created a Role dor EBS CSI Storage ------------------------*/
 
-	// Create an EKS addon using CfnAddon
-	EksAddon := awseks.NewCfnAddon(stack, jsii.String("EbsCsiAddon"), &awseks.CfnAddonProps{
-		ClusterName:           &clusterName,
-		AddonName:             jsii.String("aws-ebs-csi-driver"),
-		AddonVersion:          &AppConfig.AddonVersion,
-		ServiceAccountRoleArn: cfnRole.AttrArn(),
-	})
+	// CloudWatch Observability add-on : metrics and logs of the nodes and pods (SonarQube included)
+	if AppConfig.CloudWatchObservability.Enabled {
+		observabilityAddon(stack, clusterName, AppConfig.CloudWatchObservability)
+	}
 
-	EksAddon.Node().AddDependency(cfnRole)
+	// Cluster Autoscaler : IRSA role scoped to the auto scaling groups of the cluster
+	ClusterAutoscaler, err := autoscalerEnabled(AppConfig)
+	if err != nil {
+		fmt.Println("❌ Error in the configuration:", err)
+		os.Exit(1)
+	}
+	if ClusterAutoscaler {
+		autoscalerRole(stack, AutoscalerRole, clusterName, provider)
+	}
 
 	// Create Storage Class :  managed-csi
 	if destroy == "false" {
 		scYAMLPath := AppConfig.ScNamef
 
-		scYAML, err := os.ReadFile(scYAMLPath)
+		scYAML, err := manifest.Load(scYAMLPath, AppConfig.Overlays[AppConfig1.Index])
 		if err != nil {
 			fmt.Printf("Error reading SC YAML file: %v\n", err)
 			os.Exit(1)
@@ -322,6 +274,19 @@ func NewEksstackconfigStack(scope constructs.Construct, id string, props *Ekssta
 			os.Exit(1)
 		}
 		fmt.Println("✅ Storage Class created successfully")
+
+		if ClusterAutoscaler {
+			caYAML, err := autoscalerYAML(AppConfig, AppConfig1, clusterName, AutoscalerRole)
+			if err != nil {
+				fmt.Printf("❌ Error reading Cluster Autoscaler manifest: %v\n", err)
+				os.Exit(1)
+			}
+			err = serverSideApplyFromYAML(caYAML, clientset, dd)
+			if err != nil {
+				log.Fatalf("❌ Error applying %s file: %v\n", autoscalerManifest, err)
+			}
+			fmt.Println("✅ Cluster Autoscaler deployed successfully")
+		}
 	}
 
 	return stack
@@ -353,6 +318,24 @@ func main() {
 			glog.Fatalf("❌ Failed to create a ClientSet: %v. Exiting.", err)
 		}
 
+		// Remove Cluster Autoscaler before its role
+		if ClusterAutoscaler, _ := autoscalerEnabled(AppConfig); ClusterAutoscaler {
+			dd, err := dynamic.NewForConfig(config)
+			if err != nil {
+				log.Fatal(err)
+			}
+			clusterName := AppConfig.ClusterName + AppConfig1.Index
+			caYAML, err := autoscalerYAML(AppConfig, AppConfig1, clusterName, clusterName+"ClusterAutoscalerRole")
+			if err == nil {
+				err = deleteResourcesFromYAML(caYAML, clientset, dd)
+			}
+			if err != nil {
+				fmt.Printf("❌ Error deleting Cluster Autoscaler: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("✅ Cluster Autoscaler deleted successfully")
+		}
+
 		storageClassName := AppConfig.ScName
 
 		err = clientset.StorageV1().StorageClasses().Delete(context.TODO(), storageClassName, metav1.DeleteOptions{})
@@ -1,26 +1,304 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in eksstackconfig_test.go
-// func TestEksstackconfigStack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewEksstackconfigStack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/jsii-runtime-go"
+)
+
+const testIssuer = "https://oidc.eks.eu-west-1.amazonaws.com/id/0123456789ABCDEF"
+
+// fakeEKS returns the versions of vpc-cni for 1.28
+type fakeEKS struct {
+	eksiface.EKSAPI
+	calls int
+}
+
+func (f *fakeEKS) DescribeAddonVersionsPages(input *eks.DescribeAddonVersionsInput, fn func(*eks.DescribeAddonVersionsOutput, bool) bool) error {
+	f.calls++
+	var versions []*eks.AddonVersionInfo
+	if *input.AddonName == "vpc-cni" && *input.KubernetesVersion == "1.28" {
+		for _, version := range []string{"v1.14.1-eksbuild.1", "v1.15.4-eksbuild.1", "v1.15.10-eksbuild.1"} {
+			versions = append(versions, &eks.AddonVersionInfo{AddonVersion: aws.String(version)})
+		}
+	}
+	fn(&eks.DescribeAddonVersionsOutput{Addons: []*eks.AddonInfo{{AddonName: input.AddonName, AddonVersions: versions}}}, true)
+	return nil
+}
+
+func testConfig() Configuration {
+	return Configuration{
+		ClusterName:  "ClustWorkshop",
+		K8sVersion:   "1.28",
+		EBSRole:      "CSIDriverRole",
+		AddonVersion: "v1.25.0-eksbuild.1",
+	}
+}
+
+func testStack() awscdk.Stack {
+	return awscdk.NewStack(awscdk.NewApp(nil), jsii.String("EksStackConfig01"), &awscdk.StackProps{
+		Env: env("eu-west-1", "123456789012"),
+	})
+}
+
+// partitionArn is an ARN of the partition of the stack in the template
+func partitionArn(suffix string) map[string]interface{} {
+	return map[string]interface{}{"Fn::Join": []interface{}{"", []interface{}{"arn:", map[string]interface{}{"Ref": "AWS::Partition"}, suffix}}}
+}
+
+func TestResolveAddonsDefault(t *testing.T) {
+	addons, err := resolveAddons(testConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addons) != 1 || addons[0].Name != ebsAddonName || addons[0].Version != "v1.25.0-eksbuild.1" || addons[0].ResolveConflicts != "OVERWRITE" {
+		t.Fatalf("unexpected add-ons : %+v", addons)
+	}
+	role := addons[0].ServiceAccountRole
+	if role == nil || role.Namespace != "kube-system" || role.ServiceAccount != "ebs-csi-controller-sa" || role.ManagedPolicies[0] != "service-role/AmazonEBSCSIDriverPolicy" {
+		t.Errorf("unexpected role : %+v", role)
+	}
+}
+
+func TestResolveAddons(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.Addons = []Addon{
+		{Name: "vpc-cni", Version: "latest"},
+		{Name: "efs-csi", ServiceAccountRole: &ServiceAccountRole{}},
+		{Name: "aws-ebs-csi-driver", ResolveConflicts: "PRESERVE"},
+		{Name: "pod-identity-agent"},
+	}
+	addons, err := resolveAddons(AppConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"vpc-cni", "aws-efs-csi-driver", ebsAddonName, "eks-pod-identity-agent"}
+	for i, addon := range addons {
+		if addon.Name != names[i] {
+			t.Errorf("add-on %d : %s instead of %s", i, addon.Name, names[i])
+		}
+	}
+	if role := addons[1].ServiceAccountRole; role.ServiceAccount != "efs-csi-controller-sa" || role.ManagedPolicies[0] != "service-role/AmazonEFSCSIDriverPolicy" {
+		t.Errorf("unexpected role : %+v", role)
+	}
+	// The EBS CSI driver is in AddonVersion, without role when it is not asked
+	if addons[2].Version != "v1.25.0-eksbuild.1" || addons[2].ResolveConflicts != "PRESERVE" || addons[2].ServiceAccountRole != nil {
+		t.Errorf("unexpected EBS CSI driver : %+v", addons[2])
+	}
+}
+
+func TestResolveAddonsErrors(t *testing.T) {
+	for _, addons := range [][]Addon{
+		{{Name: "ebs-csi"}, {Name: "aws-ebs-csi-driver"}},
+		{{Name: "coredns", ServiceAccountRole: &ServiceAccountRole{}}},
+		{{Name: "kube-proxy", ResolveConflicts: "MERGE"}},
+		{{Version: "latest"}},
+		{{Name: "amazon-cloudwatch-observability"}},
+	} {
+		AppConfig := testConfig()
+		AppConfig.Addons = addons
+		AppConfig.CloudWatchObservability.Enabled = true
+		if _, err := resolveAddons(AppConfig); err == nil {
+			t.Errorf("invalid add-ons accepted : %+v", addons)
+		}
+	}
+}
+
+func TestAddAddons(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.Addons = []Addon{
+		{Name: "ebs-csi", ServiceAccountRole: &ServiceAccountRole{}},
+		{Name: "vpc-cni", Version: "latest", ConfigurationValues: map[string]interface{}{"env": map[string]interface{}{"ENABLE_PREFIX_DELEGATION": "true"}}},
+		{Name: "efs-csi", Version: "v1.7.1-eksbuild.1", ServiceAccountRole: &ServiceAccountRole{}},
+	}
+	addons, err := resolveAddons(AppConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contextFile = filepath.Join(t.TempDir(), "cdk.context.json")
+	svc := &fakeEKS{}
+	stack := testStack()
+	if err := addAddons(stack, func() eksiface.EKSAPI { return svc }, AppConfig, addons, "ClustWorkshop01", clusterOIDCProvider(stack, testIssuer)); err != nil {
+		t.Fatal(err)
+	}
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.HasResource(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"Properties": map[string]interface{}{
+			"ClusterName":           "ClustWorkshop01",
+			"AddonName":             ebsAddonName,
+			"AddonVersion":          "v1.25.0-eksbuild.1",
+			"ResolveConflicts":      "OVERWRITE",
+			"ServiceAccountRoleArn": map[string]interface{}{"Fn::GetAtt": []interface{}{"ClustWorkshop01CSIDriverRole", "Arn"}},
+		},
+		"DependsOn": []interface{}{"ClustWorkshop01CSIDriverRole"},
+	})
+	template.HasResourceProperties(jsii.String("AWS::EKS::Addon"), map[string]interface{}{
+		"AddonName":             "vpc-cni",
+		"AddonVersion":          "v1.15.10-eksbuild.1",
+		"ConfigurationValues":   `{"env":{"ENABLE_PREFIX_DELEGATION":"true"}}`,
+		"ServiceAccountRoleArn": assertions.Match_Absent(),
+	})
+	template.HasResourceProperties(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"RoleName":          "ClustWorkshop01AwsEfsCsiDriverRole",
+		"ManagedPolicyArns": []interface{}{partitionArn(":iam::aws:policy/service-role/AmazonEFSCSIDriverPolicy")},
+		"AssumeRolePolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{
+				map[string]interface{}{
+					"Action":    "sts:AssumeRoleWithWebIdentity",
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"Federated": partitionArn(":iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/0123456789ABCDEF")},
+					"Condition": map[string]interface{}{
+						"StringEquals": map[string]interface{}{
+							"oidc.eks.eu-west-1.amazonaws.com/id/0123456789ABCDEF:aud": "sts.amazonaws.com",
+							"oidc.eks.eu-west-1.amazonaws.com/id/0123456789ABCDEF:sub": "system:serviceaccount:kube-system:efs-csi-controller-sa",
+						},
+					},
+				},
+			},
+			"Version": "2012-10-17",
+		},
+	})
+	template.ResourceCountIs(jsii.String("AWS::EKS::Addon"), jsii.Number(3))
+}
+
+func TestLatestAddonVersion(t *testing.T) {
+	contextFile = filepath.Join(t.TempDir(), "cdk.context.json")
+	if err := os.WriteFile(contextFile, []byte(`{"ssm:account=123456789012:parameterName=/aws-cicd/01/eks/oidc-issuer:region=eu-west-1": "https://oidc"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	svc := &fakeEKS{}
+	eksClient := func() eksiface.EKSAPI { return svc }
+
+	// First synth : the latest version is cached in the context with the lookups
+	version, err := latestAddonVersion(testStack(), eksClient, "vpc-cni", "1.28")
+	if err != nil || version != "v1.15.10-eksbuild.1" || svc.calls != 1 {
+		t.Fatalf("latestAddonVersion : %s, %v, %d calls", version, err, svc.calls)
+	}
+	content, err := os.ReadFile(contextFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var context map[string]interface{}
+	if err := json.Unmarshal(content, &context); err != nil {
+		t.Fatal(err)
+	}
+	if len(context) != 2 || context["addon-version:addonName=vpc-cni:kubernetesVersion=1.28"] != version {
+		t.Errorf("unexpected context : %s", content)
+	}
+
+	// Next synths : the version of the context, without EKS client
+	stack := awscdk.NewStack(awscdk.NewApp(&awscdk.AppProps{Context: &context}), jsii.String("EksStackConfig01"), nil)
+	noClient := func() eksiface.EKSAPI {
+		t.Fatal("EKS client created with the version in the context")
+		return nil
+	}
+	if version, err := latestAddonVersion(stack, noClient, "vpc-cni", "1.28"); err != nil || version != "v1.15.10-eksbuild.1" {
+		t.Errorf("latestAddonVersion from the context : %s, %v", version, err)
+	}
+
+	// A new K8sVersion is not in the context
+	if _, err := latestAddonVersion(stack, eksClient, "vpc-cni", "1.29"); err == nil || svc.calls != 2 {
+		t.Errorf("version of 1.29 without DescribeAddonVersions : %v", err)
+	}
+}
+
+func TestClusterOIDCProvider(t *testing.T) {
+	stack := awscdk.NewStack(awscdk.NewApp(nil), jsii.String("EksStackConfig01"), &awscdk.StackProps{
+		Env: env("cn-north-1", "123456789012"),
+	})
+	provider := clusterOIDCProvider(stack, "https://oidc.eks.cn-north-1.amazonaws.com.cn/id/0123456789ABCDEF")
+	if provider.Issuer != "oidc.eks.cn-north-1.amazonaws.com.cn/id/0123456789ABCDEF" {
+		t.Errorf("unexpected issuer %s", provider.Issuer)
+	}
+	if !strings.HasSuffix(provider.Arn, ":iam::123456789012:oidc-provider/oidc.eks.cn-north-1.amazonaws.com.cn/id/0123456789ABCDEF") {
+		t.Errorf("unexpected provider %s", provider.Arn)
+	}
+}
+
+func TestIrsaRole(t *testing.T) {
+	stack := testStack()
+	provider := clusterOIDCProvider(stack, testIssuer)
+	irsaRole(stack, provider, irsaRoleProps{
+		RoleName:        "ClustWorkshop01ExternalDnsRole",
+		Namespace:       "external-dns",
+		ServiceAccount:  "external-dns",
+		ManagedPolicies: []string{"arn:aws:iam::123456789012:policy/ExternalDns", "AmazonRoute53ReadOnlyAccess"},
+	})
+	autoscalerRole(stack, "ClustWorkshop01ClusterAutoscalerRole", "ClustWorkshop01", provider)
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.HasResource(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"Properties": map[string]interface{}{
+			"RoleName": "ClustWorkshop01ExternalDnsRole",
+			"ManagedPolicyArns": []interface{}{
+				"arn:aws:iam::123456789012:policy/ExternalDns",
+				partitionArn(":iam::aws:policy/AmazonRoute53ReadOnlyAccess"),
+			},
+			"AssumeRolePolicyDocument": map[string]interface{}{
+				"Statement": []interface{}{
+					map[string]interface{}{
+						"Condition": map[string]interface{}{
+							"StringEquals": map[string]interface{}{
+								"oidc.eks.eu-west-1.amazonaws.com/id/0123456789ABCDEF:aud": "sts.amazonaws.com",
+								"oidc.eks.eu-west-1.amazonaws.com/id/0123456789ABCDEF:sub": "system:serviceaccount:external-dns:external-dns",
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	// The logical id of the roles is their name, kept from the first versions of the stack
+	template.HasResource(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"Properties": map[string]interface{}{
+			"RoleName":          "ClustWorkshop01ClusterAutoscalerRole",
+			"ManagedPolicyArns": assertions.Match_Absent(),
+			"Policies":          []interface{}{assertions.Match_ObjectLike(&map[string]interface{}{"PolicyName": "ClusterAutoscaler"})},
+			"AssumeRolePolicyDocument": map[string]interface{}{
+				"Statement": []interface{}{
+					map[string]interface{}{
+						"Principal": map[string]interface{}{"Federated": partitionArn(":iam::123456789012:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/0123456789ABCDEF")},
+						"Condition": map[string]interface{}{
+							"StringEquals": map[string]interface{}{
+								"oidc.eks.eu-west-1.amazonaws.com/id/0123456789ABCDEF:aud": "sts.amazonaws.com",
+								"oidc.eks.eu-west-1.amazonaws.com/id/0123456789ABCDEF:sub": "system:serviceaccount:kube-system:cluster-autoscaler",
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	roles := template.FindResources(jsii.String("AWS::IAM::Role"), nil)
+	for _, id := range []string{"ClustWorkshop01ExternalDnsRole", "ClustWorkshop01ClusterAutoscalerRole"} {
+		if _, ok := (*roles)[id]; !ok {
+			t.Errorf("missing role %s", id)
+		}
+	}
+}
+
+func TestAutoscalerYAML(t *testing.T) {
+	AppConfig := testConfig()
+	for region, arn := range map[string]string{
+		"eu-west-1":  "arn:aws:iam::123456789012:role/ClustWorkshop01ClusterAutoscalerRole",
+		"cn-north-1": "arn:aws-cn:iam::123456789012:role/ClustWorkshop01ClusterAutoscalerRole",
+	} {
+		content, err := autoscalerYAML(AppConfig, ConfAuth{Region: region, Account: "123456789012"}, "ClustWorkshop01", "ClustWorkshop01ClusterAutoscalerRole")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), arn) {
+			t.Errorf("role %s missing in the manifest of %s", arn, region)
+		}
+	}
+}
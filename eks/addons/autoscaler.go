@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/regioninfo"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Cluster Autoscaler : https://github.com/kubernetes/autoscaler/tree/master/cluster-autoscaler/cloudprovider/aws
+const (
+	autoscalerImage          = "registry.k8s.io/autoscaling/cluster-autoscaler"
+	autoscalerServiceAccount = "cluster-autoscaler"
+	autoscalerManifest       = "dist/cluster-autoscaler.yaml"
+)
+
+// autoscalerVersions are the Cluster Autoscaler releases by Kubernetes minor version
+// (the minor version of Cluster Autoscaler must match the cluster)
+var autoscalerVersions = map[string]string{
+	"1.25": "v1.25.3",
+	"1.26": "v1.26.4",
+	"1.27": "v1.27.3",
+	"1.28": "v1.28.2",
+	"1.29": "v1.29.0",
+}
+
+// ClusterAutoscaler configuration : Version overrides the release matched to K8sVersion
+type ClusterAutoscaler struct {
+	Version string
+}
+
+// autoscalerEnabled reports whether Cluster Autoscaler is deployed (Autoscaler : cluster-autoscaler),
+// Karpenter is installed by the EKS stack
+func autoscalerEnabled(AppConfig Configuration) (bool, error) {
+	switch AppConfig.Autoscaler {
+	case "", "karpenter":
+		return false, nil
+	case "cluster-autoscaler":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown Autoscaler %q : karpenter or cluster-autoscaler", AppConfig.Autoscaler)
+}
+
+// autoscalerVersion returns the Cluster Autoscaler release of the Kubernetes version of the cluster
+func autoscalerVersion(AppConfig Configuration) (string, error) {
+	if AppConfig.ClusterAutoscaler.Version != "" {
+		return AppConfig.ClusterAutoscaler.Version, nil
+	}
+	parts := strings.Split(AppConfig.K8sVersion, ".")
+	if len(parts) >= 2 {
+		if version, ok := autoscalerVersions[parts[0]+"."+parts[1]]; ok {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("no Cluster Autoscaler release for K8sVersion %q : set ClusterAutoscaler.Version", AppConfig.K8sVersion)
+}
+
+// autoscalerRole creates the IRSA role of Cluster Autoscaler : describe the auto scaling groups
+// and scale only the groups tagged for the cluster (the managed node groups are tagged by EKS)
+func autoscalerRole(stack awscdk.Stack, roleName string, clusterName string, provider oidcProvider) awsiam.CfnRole {
+	policy := awsiam.NewPolicyDocument(&awsiam.PolicyDocumentProps{
+		Statements: &[]awsiam.PolicyStatement{
+			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+				Effect: awsiam.Effect_ALLOW,
+				Actions: &[]*string{
+					jsii.String("autoscaling:DescribeAutoScalingGroups"),
+					jsii.String("autoscaling:DescribeAutoScalingInstances"),
+					jsii.String("autoscaling:DescribeLaunchConfigurations"),
+					jsii.String("autoscaling:DescribeScalingActivities"),
+					jsii.String("autoscaling:DescribeTags"),
+					jsii.String("ec2:DescribeImages"),
+					jsii.String("ec2:DescribeInstanceTypes"),
+					jsii.String("ec2:DescribeLaunchTemplateVersions"),
+					jsii.String("ec2:GetInstanceTypesFromInstanceRequirements"),
+					jsii.String("eks:DescribeNodegroup"),
+				},
+				Resources: &[]*string{jsii.String("*")},
+			}),
+			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+				Effect: awsiam.Effect_ALLOW,
+				Actions: &[]*string{
+					jsii.String("autoscaling:SetDesiredCapacity"),
+					jsii.String("autoscaling:TerminateInstanceInAutoScalingGroup"),
+				},
+				Resources: &[]*string{jsii.String("*")},
+				Conditions: &map[string]interface{}{
+					"StringEquals": map[string]interface{}{
+						"aws:ResourceTag/k8s.io/cluster-autoscaler/" + clusterName: "owned",
+						"aws:ResourceTag/k8s.io/cluster-autoscaler/enabled":        "true",
+					},
+				},
+			}),
+		},
+	})
+
+	return irsaRole(stack, provider, irsaRoleProps{
+		RoleName:       roleName,
+		ServiceAccount: autoscalerServiceAccount,
+		Policies: []interface{}{
+			&awsiam.CfnRole_PolicyProperty{
+				PolicyName:     jsii.String("ClusterAutoscaler"),
+				PolicyDocument: policy,
+			},
+		},
+	})
+}
+
+// autoscalerYAML renders the Cluster Autoscaler manifest with the role, image and cluster name
+func autoscalerYAML(AppConfig Configuration, AppConfig1 ConfAuth, clusterName string, roleName string) ([]byte, error) {
+	version, err := autoscalerVersion(AppConfig)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(autoscalerManifest)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New("cluster-autoscaler").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, map[string]string{
+		"ClusterName": clusterName,
+		"Image":       autoscalerImage + ":" + version,
+		"Region":      AppConfig1.Region,
+		// The role name is set, its ARN is known before the deployment of the stack
+		"RoleArn": fmt.Sprintf("arn:%s:iam::%s:role/%s", *regioninfo.RegionInfo_Get(jsii.String(AppConfig1.Region)).Partition(), AppConfig1.Account, roleName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
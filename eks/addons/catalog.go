@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"CDK/pkg/k8sversions"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/jsii-runtime-go"
+)
+
+const ebsAddonName = "aws-ebs-csi-driver"
+
+// Addon is an EKS managed add-on of the cluster
+type Addon struct {
+	Name                string                 // EKS name of the add-on, or a short name of k8sversions.AddonNames
+	Version             string                 // default version of EKS if empty, latest : the latest version compatible with K8sVersion
+	ConfigurationValues map[string]interface{} // configuration of the add-on (aws eks describe-addon-configuration)
+	ResolveConflicts    string                 // OVERWRITE (default), NONE or PRESERVE
+	ServiceAccountRole  *ServiceAccountRole    // IRSA role of the service account of the add-on
+}
+
+// ServiceAccountRole is the IRSA role of the service account of an add-on, the catalog gives
+// the service account and the policies of its add-ons
+type ServiceAccountRole struct {
+	Namespace       string // default kube-system
+	ServiceAccount  string
+	ManagedPolicies []string // AWS managed policy names (service-role/AmazonEBSCSIDriverPolicy) or ARNs
+}
+
+// catalogAddon is the service account of a known add-on and the policies of its role
+type catalogAddon struct {
+	serviceAccount  string
+	managedPolicies []string
+}
+
+// addonCatalog are the service accounts and policies of the known add-ons, by EKS name
+var addonCatalog = map[string]catalogAddon{
+	"vpc-cni":            {serviceAccount: "aws-node", managedPolicies: []string{"AmazonEKS_CNI_Policy"}},
+	ebsAddonName:         {serviceAccount: "ebs-csi-controller-sa", managedPolicies: []string{"service-role/AmazonEBSCSIDriverPolicy"}},
+	"aws-efs-csi-driver": {serviceAccount: "efs-csi-controller-sa", managedPolicies: []string{"service-role/AmazonEFSCSIDriverPolicy"}},
+}
+
+var resolveConflicts = map[string]bool{
+	"NONE":      true,
+	"OVERWRITE": true,
+	"PRESERVE":  true,
+}
+
+// resolveAddons returns the add-ons of the configuration with their EKS name and the defaults of
+// the catalog. Without Addons, the EBS CSI driver in version AddonVersion, the only add-on of the
+// first versions of the stack
+func resolveAddons(AppConfig Configuration) ([]Addon, error) {
+	addons := AppConfig.Addons
+	if len(addons) == 0 {
+		addons = []Addon{{Name: ebsAddonName, ServiceAccountRole: &ServiceAccountRole{}}}
+	}
+
+	seen := make(map[string]bool)
+	if AppConfig.CloudWatchObservability.Enabled {
+		seen[observabilityAddonName] = true
+	}
+	var resolved []Addon
+	for _, addon := range addons {
+		if addon.Name == "" {
+			return nil, fmt.Errorf("add-on without Name")
+		}
+		addon.Name = k8sversions.AddonName(addon.Name)
+		catalog := addonCatalog[addon.Name]
+		if seen[addon.Name] {
+			return nil, fmt.Errorf("add-on %s declared twice", addon.Name)
+		}
+		seen[addon.Name] = true
+
+		// AddonVersion is updated by the upgrade command
+		if addon.Name == ebsAddonName && addon.Version == "" {
+			addon.Version = AppConfig.AddonVersion
+		}
+		if addon.ResolveConflicts == "" {
+			addon.ResolveConflicts = "OVERWRITE"
+		}
+		if !resolveConflicts[addon.ResolveConflicts] {
+			return nil, fmt.Errorf("add-on %s : unknown ResolveConflicts %q : NONE, OVERWRITE or PRESERVE", addon.Name, addon.ResolveConflicts)
+		}
+
+		if addon.ServiceAccountRole != nil {
+			role := *addon.ServiceAccountRole
+			if role.Namespace == "" {
+				role.Namespace = "kube-system"
+			}
+			if role.ServiceAccount == "" {
+				role.ServiceAccount = catalog.serviceAccount
+			}
+			if len(role.ManagedPolicies) == 0 {
+				role.ManagedPolicies = catalog.managedPolicies
+			}
+			if role.ServiceAccount == "" || len(role.ManagedPolicies) == 0 {
+				return nil, fmt.Errorf("add-on %s : ServiceAccount and ManagedPolicies of ServiceAccountRole are required", addon.Name)
+			}
+			addon.ServiceAccountRole = &role
+		}
+		resolved = append(resolved, addon)
+	}
+	return resolved, nil
+}
+
+// addonID returns the construct id of an add-on : EbsCsiAddon for the EBS CSI driver (id of the
+// first versions of the stack), VpcCniAddon for vpc-cni
+func addonID(name string) string {
+	if name == ebsAddonName {
+		return "EbsCsiAddon"
+	}
+	return camelCase(name) + "Addon"
+}
+
+func camelCase(name string) string {
+	var id string
+	for _, part := range strings.Split(name, "-") {
+		if part != "" {
+			id += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return id
+}
+
+// contextFile is the CDK context of the project, where the latest versions of the add-ons are cached
+var contextFile = "cdk.context.json"
+
+// latestAddonVersion returns the latest version of an add-on compatible with the Kubernetes version,
+// from the CDK context : DescribeAddonVersions is only called the first time, then the version is
+// cached in cdk.context.json like the lookups, the template only changes with K8sVersion
+func latestAddonVersion(stack awscdk.Stack, eksClient func() eksiface.EKSAPI, addonName string, k8sVersion string) (string, error) {
+	key := fmt.Sprintf("addon-version:addonName=%s:kubernetesVersion=%s", addonName, k8sVersion)
+	if version, ok := stack.Node().TryGetContext(jsii.String(key)).(string); ok && version != "" {
+		return version, nil
+	}
+	version, err := k8sversions.LatestAddonVersion(eksClient(), addonName, k8sVersion)
+	if err != nil {
+		return "", err
+	}
+
+	values := make(map[string]interface{})
+	if content, err := os.ReadFile(contextFile); err == nil {
+		if err := json.Unmarshal(content, &values); err != nil {
+			return "", fmt.Errorf("%s : %w", contextFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	values[key] = version
+	content, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return version, os.WriteFile(contextFile, append(content, '\n'), 0644)
+}
+
+// addAddons creates the managed add-ons with the IRSA role of their service account. The role of
+// the EBS CSI driver keeps its name <ClusterName><Index><EBSRole>, the others are named
+// <ClusterName><Index><Addon>Role. eksClient returns the EKS client of the latest versions, only
+// created when a version is not in the context
+func addAddons(stack awscdk.Stack, eksClient func() eksiface.EKSAPI, AppConfig Configuration, addons []Addon, clusterName string, provider oidcProvider) error {
+	for _, addon := range addons {
+		props := &awseks.CfnAddonProps{
+			ClusterName:      jsii.String(clusterName),
+			AddonName:        jsii.String(addon.Name),
+			ResolveConflicts: jsii.String(addon.ResolveConflicts),
+		}
+
+		switch addon.Version {
+		case "":
+		case "latest":
+			version, err := latestAddonVersion(stack, eksClient, addon.Name, AppConfig.K8sVersion)
+			if err != nil {
+				return fmt.Errorf("add-on %s : %w", addon.Name, err)
+			}
+			props.AddonVersion = jsii.String(version)
+		default:
+			props.AddonVersion = jsii.String(addon.Version)
+		}
+
+		if len(addon.ConfigurationValues) > 0 {
+			values, err := json.Marshal(addon.ConfigurationValues)
+			if err != nil {
+				return fmt.Errorf("add-on %s : ConfigurationValues : %w", addon.Name, err)
+			}
+			props.ConfigurationValues = jsii.String(string(values))
+		}
+
+		var role awsiam.CfnRole
+		if addon.ServiceAccountRole != nil {
+			roleName := clusterName + camelCase(addon.Name) + "Role"
+			if addon.Name == ebsAddonName {
+				roleName = clusterName + AppConfig.EBSRole
+			}
+			role = irsaRole(stack, provider, irsaRoleProps{
+				RoleName:        roleName,
+				Namespace:       addon.ServiceAccountRole.Namespace,
+				ServiceAccount:  addon.ServiceAccountRole.ServiceAccount,
+				ManagedPolicies: addon.ServiceAccountRole.ManagedPolicies,
+			})
+			props.ServiceAccountRoleArn = role.AttrArn()
+		}
+
+		cfnAddon := awseks.NewCfnAddon(stack, jsii.String(addonID(addon.Name)), props)
+		if role != nil {
+			cfnAddon.Node().AddDependency(role)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// oidcProvider is the OIDC provider of the cluster, trusted by the IRSA roles of its service accounts
+type oidcProvider struct {
+	Arn    string // arn:<Partition>:iam::<Account>:oidc-provider/<Issuer>
+	Issuer string // oidc.eks.<Region>.amazonaws.com/id/<ID>, the prefix of the aud and sub conditions
+}
+
+// clusterOIDCProvider returns the OIDC provider of the issuer URL of the cluster, the domain of the
+// issuer is kept as is (amazonaws.com.cn in China)
+func clusterOIDCProvider(stack awscdk.Stack, oidcIssuer string) oidcProvider {
+	issuer := strings.TrimPrefix(oidcIssuer, "https://")
+	return oidcProvider{
+		Arn:    fmt.Sprintf("arn:%s:iam::%s:oidc-provider/%s", *stack.Partition(), *stack.Account(), issuer),
+		Issuer: issuer,
+	}
+}
+
+// irsaRoleProps is the service account of an IRSA role and its policies
+type irsaRoleProps struct {
+	RoleName        string
+	Namespace       string // default kube-system
+	ServiceAccount  string
+	ManagedPolicies []string      // AWS managed policy names (service-role/AmazonEBSCSIDriverPolicy) or ARNs
+	Policies        []interface{} // inline policies (awsiam.CfnRole_PolicyProperty)
+}
+
+// managedPolicyArn returns the ARN of an AWS managed policy given by name or ARN
+func managedPolicyArn(stack awscdk.Stack, policy string) string {
+	if strings.HasPrefix(policy, "arn:") {
+		return policy
+	}
+	return fmt.Sprintf("arn:%s:iam::aws:policy/%s", *stack.Partition(), policy)
+}
+
+// irsaRole creates the IRSA role of a service account : only the service account of the namespace
+// can assume the role, with a token of the OIDC provider of the cluster for STS
+func irsaRole(stack awscdk.Stack, provider oidcProvider, props irsaRoleProps) awsiam.CfnRole {
+	if props.Namespace == "" {
+		props.Namespace = "kube-system"
+	}
+	assumeRolePolicy := awsiam.NewPolicyDocument(&awsiam.PolicyDocumentProps{
+		Statements: &[]awsiam.PolicyStatement{
+			awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+				Effect:  awsiam.Effect_ALLOW,
+				Actions: &[]*string{jsii.String("sts:AssumeRoleWithWebIdentity")},
+				Principals: &[]awsiam.IPrincipal{
+					awsiam.NewFederatedPrincipal(jsii.String(provider.Arn), nil, nil),
+				},
+				Conditions: &map[string]interface{}{
+					"StringEquals": map[string]interface{}{
+						provider.Issuer + ":aud": "sts.amazonaws.com",
+						provider.Issuer + ":sub": "system:serviceaccount:" + props.Namespace + ":" + props.ServiceAccount,
+					},
+				},
+			}),
+		},
+	})
+
+	roleProps := &awsiam.CfnRoleProps{
+		AssumeRolePolicyDocument: assumeRolePolicy,
+		RoleName:                 jsii.String(props.RoleName),
+	}
+	if len(props.ManagedPolicies) > 0 {
+		var policyArns []*string
+		for _, policy := range props.ManagedPolicies {
+			policyArns = append(policyArns, jsii.String(managedPolicyArn(stack, policy)))
+		}
+		roleProps.ManagedPolicyArns = &policyArns
+	}
+	if len(props.Policies) > 0 {
+		roleProps.Policies = &props.Policies
+	}
+	return awsiam.NewCfnRole(stack, jsii.String(props.RoleName), roleProps)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Amazon CloudWatch Observability : https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/install-CloudWatch-Observability-EKS-addon.html
+const observabilityAddonName = "amazon-cloudwatch-observability"
+
+// CloudWatchObservability configuration : the CloudWatch agent (Container Insights metrics) and
+// Fluent Bit (logs of the nodes and pods) use the CloudWatchAgentServerPolicy of the node roles
+type CloudWatchObservability struct {
+	Enabled bool
+	Version string // default version of EKS for the cluster if empty
+}
+
+// observabilityAddon installs the CloudWatch Observability add-on in the namespace amazon-cloudwatch
+func observabilityAddon(stack awscdk.Stack, clusterName string, config CloudWatchObservability) awseks.CfnAddon {
+	var version *string
+	if config.Version != "" {
+		version = jsii.String(config.Version)
+	}
+	return awseks.NewCfnAddon(stack, jsii.String("CloudWatchObservabilityAddon"), &awseks.CfnAddonProps{
+		ClusterName:  jsii.String(clusterName),
+		AddonName:    jsii.String(observabilityAddonName),
+		AddonVersion: version,
+	})
+}
@@ -4,17 +4,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsssm"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
-	kubectlv28 "github.com/cdklabs/awscdk-kubectl-go/kubectlv28/v2"
 )
 
 type EksStackProps struct {
@@ -41,6 +43,67 @@ type Configuration struct {
 	AddonVersion string
 	ScName       string
 	ScNamef      string
+	IpFamily     string
+	// Security groups of the VPC stack (SecurityGroups Name in vpc/config.json)
+	ClusterSecurityGroup string
+	NodeSecurityGroups   []string
+	// Managed node groups, without NodeGroups a single node group of Workernode Instance InstanceSize
+	NodeGroups []NodeGroup
+	// ARNs of the users and roles allowed to assume the admin role EksAdminRole, besides the deployer
+	AdminPrincipals []string
+	// API endpoint : public (default), private or public-and-private, the public endpoint restricted to PublicAccessCidrs
+	EndpointAccess    string
+	PublicAccessCidrs []string
+	// Bastion reached with SSM for the private endpoint (eks/tunnel.sh)
+	Bastion bool
+	// Envelope encryption of the Kubernetes secrets : a KMS key of the stack, or the key SecretsKeyArn
+	SecretsEncryption bool
+	SecretsKeyArn     string
+	// Control plane logs sent to CloudWatch Logs : api, audit, authenticator, controllerManager, scheduler
+	ControlPlaneLogs []string
+	// Authentication mode : CONFIG_MAP (aws-auth, default), API_AND_CONFIG_MAP or API (access entries)
+	AuthenticationMode string
+	AccessEntries      []AccessEntry
+	// Autoscaler of the nodes : "" (none), karpenter or cluster-autoscaler (addons stack)
+	Autoscaler string
+	Karpenter  Karpenter
+}
+
+// NodeGroup is a managed node group of the cluster
+type NodeGroup struct {
+	Name          string
+	InstanceTypes []string
+	CapacityType  string   // on-demand (default) or spot
+	MinSize       *float64 // default 1
+	MaxSize       *float64 // default DesiredSize
+	DesiredSize   *float64 // default MinSize
+	DiskSize      float64  // GiB, default 20
+	AmiType       string   // AL2_x86_64, AL2_ARM_64, AL2023_x86_64_STANDARD, AL2023_ARM_64_STANDARD, BOTTLEROCKET_x86_64, BOTTLEROCKET_ARM_64, default from the instance types
+	Labels        map[string]string
+	Taints        []Taint
+}
+
+// Taint of the nodes of a node group
+type Taint struct {
+	Key    string
+	Value  string
+	Effect string // NoSchedule, PreferNoSchedule or NoExecute
+}
+
+// amiTypes are the AMI types of the node groups (AL2023 is not in the CDK enum yet)
+var amiTypes = map[string]awseks.NodegroupAmiType{
+	"AL2_x86_64":             awseks.NodegroupAmiType_AL2_X86_64,
+	"AL2_ARM_64":             awseks.NodegroupAmiType_AL2_ARM_64,
+	"AL2023_x86_64_STANDARD": awseks.NodegroupAmiType("AL2023_x86_64_STANDARD"),
+	"AL2023_ARM_64_STANDARD": awseks.NodegroupAmiType("AL2023_ARM_64_STANDARD"),
+	"BOTTLEROCKET_x86_64":    awseks.NodegroupAmiType_BOTTLEROCKET_X86_64,
+	"BOTTLEROCKET_ARM_64":    awseks.NodegroupAmiType_BOTTLEROCKET_ARM_64,
+}
+
+var taintEffects = map[string]awseks.TaintEffect{
+	"NoSchedule":       awseks.TaintEffect_NO_SCHEDULE,
+	"PreferNoSchedule": awseks.TaintEffect_PREFER_NO_SCHEDULE,
+	"NoExecute":        awseks.TaintEffect_NO_EXECUTE,
 }
 
 func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
@@ -67,7 +130,214 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	return configcrd, configjs
 }
 
-func NewEksStack(scope constructs.Construct, id string, props *EksStackProps, AppConfig Configuration, AppConfig1 ConfAuth) awscdk.Stack {
+// vpcParameterPrefix is the SSM path of the parameters written by the VPC stack by Index
+func vpcParameterPrefix(index string) string {
+	return "/aws-cicd/" + index + "/vpc/"
+}
+
+// eksParameterPrefix is the SSM path of the EKS cluster parameters by Index, read by the addons stack
+func eksParameterPrefix(index string) string {
+	return "/aws-cicd/" + index + "/eks/"
+}
+
+var clusterLoggingTypes = map[string]awseks.ClusterLoggingTypes{
+	"api":               awseks.ClusterLoggingTypes_API,
+	"audit":             awseks.ClusterLoggingTypes_AUDIT,
+	"authenticator":     awseks.ClusterLoggingTypes_AUTHENTICATOR,
+	"controllerManager": awseks.ClusterLoggingTypes_CONTROLLER_MANAGER,
+	"scheduler":         awseks.ClusterLoggingTypes_SCHEDULER,
+}
+
+// controlPlaneLogging returns the log types of the control plane sent to the log group
+// /aws/eks/<cluster>/cluster (nil without ControlPlaneLogs)
+func controlPlaneLogging(AppConfig Configuration) (*[]awseks.ClusterLoggingTypes, error) {
+	if len(AppConfig.ControlPlaneLogs) == 0 {
+		return nil, nil
+	}
+	var types []awseks.ClusterLoggingTypes
+	for _, name := range AppConfig.ControlPlaneLogs {
+		logType, ok := clusterLoggingTypes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown ControlPlaneLogs type %q : api, audit, authenticator, controllerManager or scheduler", name)
+		}
+		types = append(types, logType)
+	}
+	return &types, nil
+}
+
+// ipFamily returns the IP family of the cluster : ipv4 (default) or ipv6 (the VPC subnets must be dual-stack)
+func ipFamily(AppConfig Configuration) (awseks.IpFamily, error) {
+	switch AppConfig.IpFamily {
+	case "", "ipv4":
+		return awseks.IpFamily_IP_V4, nil
+	case "ipv6":
+		return awseks.IpFamily_IP_V6, nil
+	}
+	return "", fmt.Errorf("unknown IpFamily %q : ipv4 or ipv6", AppConfig.IpFamily)
+}
+
+// ipv6CniPolicy is the policy needed by the VPC CNI to assign IPv6 addresses to the pods
+// (AmazonEKS_CNI_Policy only covers IPv4)
+func ipv6CniPolicy() []awsiam.PolicyStatement {
+	return []awsiam.PolicyStatement{
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Effect: awsiam.Effect_ALLOW,
+			Actions: &[]*string{
+				jsii.String("ec2:AssignIpv6Addresses"),
+				jsii.String("ec2:DescribeInstances"),
+				jsii.String("ec2:DescribeTags"),
+				jsii.String("ec2:DescribeNetworkInterfaces"),
+				jsii.String("ec2:DescribeInstanceTypes"),
+			},
+			Resources: &[]*string{jsii.String("*")},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Effect:    awsiam.Effect_ALLOW,
+			Actions:   &[]*string{jsii.String("ec2:CreateTags")},
+			Resources: &[]*string{jsii.String("arn:aws:ec2:*:*:network-interface/*")},
+		}),
+	}
+}
+
+// vpcSecurityGroups imports the security groups of the VPC stack by Name : the IDs are
+// resolved by CloudFormation at deployment from the SSM parameters security-groups/<Name>
+func vpcSecurityGroups(stack awscdk.Stack, index string, names []string) map[string]awsec2.ISecurityGroup {
+	groups := make(map[string]awsec2.ISecurityGroup)
+	for _, name := range names {
+		if name == "" || groups[name] != nil {
+			continue
+		}
+		groupID := awsssm.StringParameter_ValueForStringParameter(stack, jsii.String(vpcParameterPrefix(index)+"security-groups/"+name), nil)
+		groups[name] = awsec2.SecurityGroup_FromSecurityGroupId(stack, jsii.String("SG-"+name), groupID, &awsec2.SecurityGroupImportOptions{
+			Mutable: jsii.Bool(false),
+		})
+	}
+	return groups
+}
+
+// nodeGroups returns the node groups of the configuration, or the node group of Workernode
+// Instance InstanceSize : the CDK default capacity, same construct ID "DefaultCapacity" (the
+// nodegroup of the existing clusters is kept) with the role worker of the addons stack
+func nodeGroups(AppConfig Configuration) []NodeGroup {
+	if len(AppConfig.NodeGroups) > 0 {
+		return AppConfig.NodeGroups
+	}
+	if AppConfig.Workernode == 0 {
+		return nil
+	}
+	instanceType := awsec2.InstanceType_Of(awsec2.InstanceClass(AppConfig.Instance), awsec2.InstanceSize(AppConfig.InstanceSize))
+	return []NodeGroup{{
+		Name:          "DefaultCapacity",
+		InstanceTypes: []string{*instanceType.ToString()},
+		MinSize:       &AppConfig.Workernode,
+		Labels:        map[string]string{"role": "worker"},
+	}}
+}
+
+// nodegroupOptions returns the options of a node group, the disk size is set in the launch
+// template when the node group has one
+func nodegroupOptions(group NodeGroup, launchTemplate bool) (*awseks.NodegroupOptions, error) {
+	if group.Name == "" || len(group.InstanceTypes) == 0 {
+		return nil, fmt.Errorf("node group %q : Name and InstanceTypes are required", group.Name)
+	}
+	var instanceTypes []awsec2.InstanceType
+	for _, instanceType := range group.InstanceTypes {
+		instanceTypes = append(instanceTypes, awsec2.NewInstanceType(jsii.String(instanceType)))
+	}
+
+	options := &awseks.NodegroupOptions{
+		InstanceTypes: &instanceTypes,
+		MinSize:       group.MinSize,
+		MaxSize:       group.MaxSize,
+		DesiredSize:   group.DesiredSize,
+	}
+
+	switch group.CapacityType {
+	case "", "on-demand":
+	case "spot":
+		options.CapacityType = awseks.CapacityType_SPOT
+	default:
+		return nil, fmt.Errorf("node group %s : unknown CapacityType %q : on-demand or spot", group.Name, group.CapacityType)
+	}
+
+	if group.AmiType != "" {
+		amiType, ok := amiTypes[group.AmiType]
+		if !ok {
+			return nil, fmt.Errorf("node group %s : unknown AmiType %q", group.Name, group.AmiType)
+		}
+		options.AmiType = amiType
+	}
+
+	if group.DiskSize > 0 && !launchTemplate {
+		options.DiskSize = &group.DiskSize
+	}
+
+	if len(group.Labels) > 0 {
+		labels := make(map[string]*string)
+		for key, value := range group.Labels {
+			labels[key] = jsii.String(value)
+		}
+		options.Labels = &labels
+	}
+
+	var taints []*awseks.TaintSpec
+	for _, taint := range group.Taints {
+		effect, ok := taintEffects[taint.Effect]
+		if !ok {
+			return nil, fmt.Errorf("node group %s : unknown taint Effect %q : NoSchedule, PreferNoSchedule or NoExecute", group.Name, taint.Effect)
+		}
+		taints = append(taints, &awseks.TaintSpec{
+			Key:    jsii.String(taint.Key),
+			Value:  jsii.String(taint.Value),
+			Effect: effect,
+		})
+	}
+	if len(taints) > 0 {
+		options.Taints = &taints
+	}
+	return options, nil
+}
+
+// nodeLaunchTemplate creates the launch template of a nodegroup with its security groups :
+// the cluster security group must be in the list, EKS only adds it without launch template.
+// The disk size of the node group is set on the root volume (data volume for Bottlerocket)
+func nodeLaunchTemplate(stack awscdk.Stack, id string, securityGroupIds []*string, group NodeGroup) *awseks.LaunchTemplateSpec {
+	var blockDeviceMappings *[]interface{}
+	if group.DiskSize > 0 {
+		deviceName := "/dev/xvda"
+		if strings.HasPrefix(group.AmiType, "BOTTLEROCKET") {
+			deviceName = "/dev/xvdb"
+		}
+		blockDeviceMappings = &[]interface{}{
+			&awsec2.CfnLaunchTemplate_BlockDeviceMappingProperty{
+				DeviceName: &deviceName,
+				Ebs: &awsec2.CfnLaunchTemplate_EbsProperty{
+					VolumeSize: &group.DiskSize,
+					VolumeType: jsii.String("gp3"),
+				},
+			},
+		}
+	}
+
+	launchTemplate := awsec2.NewCfnLaunchTemplate(stack, &id, &awsec2.CfnLaunchTemplateProps{
+		LaunchTemplateData: &awsec2.CfnLaunchTemplate_LaunchTemplateDataProperty{
+			SecurityGroupIds:    &securityGroupIds,
+			BlockDeviceMappings: blockDeviceMappings,
+			MetadataOptions: &awsec2.CfnLaunchTemplate_MetadataOptionsProperty{
+				HttpTokens:              jsii.String("required"),
+				HttpPutResponseHopLimit: jsii.Number(2),
+			},
+		},
+	})
+	return &awseks.LaunchTemplateSpec{
+		Id:      launchTemplate.Ref(),
+		Version: launchTemplate.AttrLatestVersionNumber(),
+	}
+}
+
+// NewEksStack creates the EKS cluster. svc gets the caller identity trusted by the admin role : the
+// STS client in main, a fake in the tests (no credentials needed)
+func NewEksStack(scope constructs.Construct, id string, props *EksStackProps, AppConfig Configuration, AppConfig1 ConfAuth, svc stsiface.STSAPI) awscdk.Stack {
 	var sprops awscdk.StackProps
 	if props != nil {
 		sprops = props.StackProps
@@ -78,25 +348,7 @@ func NewEksStack(scope constructs.Construct, id string, props *EksStackProps, Ap
 	var clusterName = AppConfig.ClusterName + AppConfig1.Index
 	var AdmRole = clusterName + AppConfig.EksAdminRole
 
-	// ARN policies for Role eksadmin
-	var policyArn2 = "arn:aws:iam::aws:policy/AmazonEKSWorkerNodePolicy"
-	var policyArn3 = "arn:aws:iam::aws:policy/AmazonEKS_CNI_Policy"
-	var policyArn4 = "arn:aws:iam::aws:policy/ElasticLoadBalancingFullAccess"
-	var policyArn5 = "arn:aws:iam::aws:policy/AmazonEC2ContainerRegistryReadOnly"
-	var policyArn6 = "arn:aws:iam::aws:policy/CloudWatchAgentServerPolicy"
-	var policyArn7 = "arn:aws:iam::aws:policy/AmazonEC2FullAccess"
-	var policyArn8 = "arn:aws:iam::aws:policy/AmazonEKSVPCResourceController"
-	var policyArn9 = "arn:aws:iam::aws:policy/AmazonEKSClusterPolicy"
-
-	// Open AWS session
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: &AppConfig1.Region,
-	}))
-
 	//------------------------Get Sts Account --------------------------------------//
-	// Create an STS service client
-	svc := sts.New(sess)
-
 	// Create an STS API request to get caller identity
 	inputuser := &sts.GetCallerIdentityInput{}
 	resultuser, err := svc.GetCallerIdentity(inputuser)
@@ -112,81 +364,157 @@ func NewEksStack(scope constructs.Construct, id string, props *EksStackProps, Ap
 	//------------------------END Get Sts Account --------------------------------------//
 
 	// Get VPC and Set Variables for EC2 instance
-	PartVpc := awsec2.Vpc_FromLookup(stack, &AppConfig.VPCid, &awsec2.VpcLookupOptions{VpcId: &AppConfig.VPCid})
-
-	Instance := AppConfig.Instance
-	InstanceSZ := AppConfig.InstanceSize
-
-	// Define the trusted service principals dor EKS RoleAdmin
-	trustedService1 := awsiam.NewServicePrincipal(jsii.String("eks.amazonaws.com"), nil)
-	trustedService2 := awsiam.NewArnPrincipal(&ArnPrincipal)
-	trustedPrincipals := awsiam.NewCompositePrincipal(trustedService1, trustedService2)
-
-	// Define an IAM policy statement with multiple actions
-	myPolicyStatement := awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
-		Effect: awsiam.Effect_ALLOW,
-		Actions: &[]*string{
-			jsii.String("ec2:CreateVolume"),
-			jsii.String("ec2:DeleteVolume"),
-			jsii.String("ec2:DetachVolume"),
-			jsii.String("ec2:AttachVolume"),
-			jsii.String("ec2:DescribeInstances"),
-			jsii.String("ec2:CreateTags"),
-			jsii.String("ec2:DeleteTags"),
-			jsii.String("ec2:DescribeTags"),
-			jsii.String("ec2:DescribeVolumes"),
-		},
-		Resources: &[]*string{
-			jsii.String("*"),
-		},
-	})
+	// Without VPCid, the ID published by the VPC stack of the same Index is used
+	VpcID := jsii.String(AppConfig.VPCid)
+	if AppConfig.VPCid == "" {
+		VpcID = awsssm.StringParameter_ValueFromLookup(stack, jsii.String(vpcParameterPrefix(AppConfig1.Index)+"id"))
+	}
+	PartVpc := awsec2.Vpc_FromLookup(stack, jsii.String("Vpc"), &awsec2.VpcLookupOptions{VpcId: VpcID})
 
-	// Define IAM role for the EKS cluster.
-	eksAdminRole := awsiam.NewRole(stack, &AdmRole, &awsiam.RoleProps{
-		AssumedBy: trustedPrincipals,
-		RoleName:  &AdmRole,
-	})
+	IpFamily, err := ipFamily(AppConfig)
+	if err != nil {
+		fmt.Println("❌ Error in the configuration:", err)
+		os.Exit(1)
+	}
+
+	// kubectl layer and ALB controller of the Kubernetes version
+	K8sVersion, err := clusterVersion(AppConfig)
+	if err != nil {
+		fmt.Println("❌ Error in the configuration:", err)
+		os.Exit(1)
+	}
+
+	EndpointAccess, err := endpointAccess(AppConfig)
+	if err != nil {
+		fmt.Println("❌ Error in the configuration:", err)
+		os.Exit(1)
+	}
+
+	ClusterLogging, err := controlPlaneLogging(AppConfig)
+	if err != nil {
+		fmt.Println("❌ Error in the configuration:", err)
+		os.Exit(1)
+	}
 
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEKSWorkerNodePolicy"), &policyArn2))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEKS_CNI_Policy"), &policyArn3))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("ElasticLoadBalancingFullAccess"), &policyArn4))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEC2ContainerRegistryReadOnly"), &policyArn5))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("CloudWatchAgentServerPolicy"), &policyArn6))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEC2FullAccess"), &policyArn7))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEKSVPCResourceController"), &policyArn8))
-	eksAdminRole.AddManagedPolicy(awsiam.ManagedPolicy_FromManagedPolicyArn(stack, jsii.String("AmazonEKSClusterPolicy"), &policyArn9))
-	eksAdminRole.AddToPolicy(myPolicyStatement)
+	// Security groups of the VPC stack attached to the control plane and the nodes
+	SecurityGroups := vpcSecurityGroups(stack, AppConfig1.Index, append([]string{AppConfig.ClusterSecurityGroup}, AppConfig.NodeSecurityGroups...))
+	ClusterSG := SecurityGroups[AppConfig.ClusterSecurityGroup]
+
+	// Separate roles for the control plane, the nodes and the administrators
+	ClusterRole := clusterRole(stack, clusterName)
+	NodeRole := nodeRole(stack, clusterName)
+	eksAdminRole := adminRole(stack, AdmRole, clusterName, ArnPrincipal, AppConfig)
+
+	// KMS key of the secrets, set at the creation of the cluster only
+	SecretsKey, err := secretsKey(stack, clusterName, ClusterRole, AppConfig)
+	if err != nil {
+		fmt.Println("❌ Error in the configuration:", err)
+		os.Exit(1)
+	}
 
 	// Create the EKS cluster.
 	eksCluster := awseks.NewCluster(stack, &clusterName, &awseks.ClusterProps{
-		ClusterName:             &clusterName,
-		Vpc:                     PartVpc,
-		Role:                    eksAdminRole,
-		MastersRole:             eksAdminRole,
-		Version:                 awseks.KubernetesVersion_Of(&AppConfig.K8sVersion),
-		KubectlLayer:            kubectlv28.NewKubectlV28Layer(stack, jsii.String("kubectl128layer")),
-		DefaultCapacity:         &AppConfig.Workernode,
-		DefaultCapacityInstance: awsec2.InstanceType_Of(awsec2.InstanceClass(Instance), awsec2.InstanceSize(InstanceSZ)),
-		DefaultCapacityType:     awseks.DefaultCapacityType_NODEGROUP,
-		EndpointAccess:          awseks.EndpointAccess_PUBLIC(),
-		OutputConfigCommand:     jsii.Bool(true),
+		ClusterName:          &clusterName,
+		Vpc:                  PartVpc,
+		Role:                 ClusterRole,
+		MastersRole:          eksAdminRole,
+		Version:              awseks.KubernetesVersion_Of(&AppConfig.K8sVersion),
+		KubectlLayer:         K8sVersion.kubectlLayer(stack),
+		DefaultCapacity:      jsii.Number(0),
+		EndpointAccess:       EndpointAccess,
+		SecretsEncryptionKey: SecretsKey,
+		ClusterLogging:       ClusterLogging,
+		IpFamily:             IpFamily,
+		SecurityGroup:        ClusterSG,
+		OutputConfigCommand:  jsii.Bool(true),
 		Tags: &map[string]*string{
 			"Env":                               jsii.String("Dev"),
 			"k8s.io/cluster-autoscaler/enabled": jsii.String("true"),
 		},
 		AlbController: &awseks.AlbControllerOptions{
-			Version: awseks.AlbControllerVersion_V2_5_1(),
+			Version: K8sVersion.albController(),
 		},
 	})
 
 	//Add Dependency : waiting The Adim Role created
 	eksCluster.Node().AddDependency(eksAdminRole)
 
+	if AppConfig.Bastion {
+		addBastion(stack, PartVpc, eksCluster, clusterName)
+	}
+
+	// Access entries of the admin role and the principals of the configuration
+	AccessEntries, err := accessEntriesEnabled(AppConfig)
+	if err != nil {
+		fmt.Println("❌ Error in the configuration:", err)
+		os.Exit(1)
+	}
+	var Authentication constructs.IDependable
+	if AccessEntries {
+		Authentication, err = addAccessEntries(stack, eksCluster, clusterName, eksAdminRole.RoleArn(), AppConfig)
+		if err != nil {
+			fmt.Println("❌ Error in the access entries configuration:", err)
+			os.Exit(1)
+		}
+	}
+
+	// Managed node groups, with the node security groups in a launch template
+	var securityGroupIds []*string
+	if len(AppConfig.NodeSecurityGroups) > 0 {
+		securityGroupIds = append(securityGroupIds, eksCluster.ClusterSecurityGroupId())
+		for _, name := range AppConfig.NodeSecurityGroups {
+			securityGroupIds = append(securityGroupIds, SecurityGroups[name].SecurityGroupId())
+		}
+	}
+	for _, group := range nodeGroups(AppConfig) {
+		options, err := nodegroupOptions(group, securityGroupIds != nil)
+		if err != nil {
+			fmt.Println("❌ Error in the node groups configuration:", err)
+			os.Exit(1)
+		}
+		options.NodeRole = NodeRole
+		if securityGroupIds != nil {
+			options.LaunchTemplateSpec = nodeLaunchTemplate(stack, group.Name+"LaunchTemplate", securityGroupIds, group)
+		}
+		eksCluster.AddNodegroupCapacity(jsii.String(group.Name), options)
+	}
+
+	// IPv6 pods : the CNI of the nodes assigns IPv6 addresses
+	if IpFamily == awseks.IpFamily_IP_V6 {
+		for _, statement := range ipv6CniPolicy() {
+			NodeRole.AddToPrincipalPolicy(statement)
+		}
+	}
+
+	// Karpenter launches the nodes of its NodePools in the private subnets
+	switch AppConfig.Autoscaler {
+	case "", "cluster-autoscaler":
+	case "karpenter":
+		var subnetIds []*string
+		for _, subnet := range *PartVpc.PrivateSubnets() {
+			subnetIds = append(subnetIds, subnet.SubnetId())
+		}
+		if err := addKarpenter(stack, eksCluster, AppConfig, clusterName, subnetIds, Authentication); err != nil {
+			fmt.Println("❌ Error in the Karpenter configuration:", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("❌ Error in the configuration: unknown Autoscaler %q : karpenter or cluster-autoscaler\n", AppConfig.Autoscaler)
+		os.Exit(1)
+	}
+
 	// Output the EKS cluster name.
 	awscdk.NewCfnOutput(stack, jsii.String("EksClusterName"), &awscdk.CfnOutputProps{
 		Value: eksCluster.ClusterName(),
 	})
 
+	// OIDC issuer of the cluster for the IRSA roles of the addons stack
+	awsssm.NewStringParameter(stack, jsii.String("OidcIssuerParameter"), &awsssm.StringParameterProps{
+		ParameterName: jsii.String(eksParameterPrefix(AppConfig1.Index) + "oidc-issuer"),
+		Description:   jsii.String("OIDC issuer of the EKS cluster " + clusterName),
+		StringValue:   eksCluster.ClusterOpenIdConnectIssuer(),
+	})
+
 	return stack
 }
 
@@ -201,11 +529,16 @@ func main() {
 
 	app := awscdk.NewApp(nil)
 
+	// Open AWS session
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region: &AppConfig1.Region,
+	}))
+
 	NewEksStack(app, Stack1, &EksStackProps{
 		awscdk.StackProps{
 			Env: env(AppConfig1.Region, AppConfig1.Account),
 		},
-	}, AppConfig, AppConfig1)
+	}, AppConfig, AppConfig1, sts.New(sess))
 
 	app.Synth(nil)
 
@@ -1,26 +1,553 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in eks_test.go
-// func TestEksStack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewEksStack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"CDK/pkg/k8sversions"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// fakeSTS returns a fixed caller identity instead of calling STS
+type fakeSTS struct {
+	stsiface.STSAPI
+}
+
+func (f *fakeSTS) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{
+		Account: jsii.String("123456789012"),
+		Arn:     jsii.String("arn:aws:iam::123456789012:user/deployer"),
+	}, nil
+}
+
+func testConfig() Configuration {
+	return Configuration{
+		ClusterName:  "TestCluster",
+		VPCid:        "vpc-0123456789",
+		K8sVersion:   "1.28",
+		Workernode:   2,
+		EksAdminRole: "AdminRole",
+		Instance:     "T4G",
+		InstanceSize: "XLARGE",
+	}
+}
+
+// testEksStack synthesizes the EKS stack in a fixed environment (the VPC is resolved with dummy
+// context values)
+func testEksStack(AppConfig Configuration) assertions.Template {
+	app := awscdk.NewApp(nil)
+	AppConfig1 := ConfAuth{Region: "eu-west-1", Account: "123456789012", Index: "01"}
+
+	stack := NewEksStack(app, "EksStack01", &EksStackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1, &fakeSTS{})
+	return assertions.Template_FromStack(stack, nil)
+}
+
+// roleByName returns the properties of the role RoleName
+func roleByName(t *testing.T, template assertions.Template, roleName string) map[string]interface{} {
+	roles := template.FindResources(jsii.String("AWS::IAM::Role"), map[string]interface{}{
+		"Properties": map[string]interface{}{"RoleName": roleName},
+	})
+	for _, role := range *roles {
+		return (*role)["Properties"].(map[string]interface{})
+	}
+	t.Fatalf("role %s not found", roleName)
+	return nil
+}
+
+func TestEksRolesLeastPrivilege(t *testing.T) {
+	template := testEksStack(testConfig())
+
+	// No full access managed policy on any role of the stack
+	for id, role := range *template.FindResources(jsii.String("AWS::IAM::Role"), nil) {
+		policies, _ := json.Marshal((*role)["Properties"].(map[string]interface{})["ManagedPolicyArns"])
+		if strings.Contains(string(policies), "FullAccess") {
+			t.Errorf("role %s has a full access managed policy : %s", id, policies)
+		}
+	}
+	// No wildcard action in the inline policies
+	for id, policy := range *template.FindResources(jsii.String("AWS::IAM::Policy"), nil) {
+		document, _ := json.Marshal((*policy)["Properties"].(map[string]interface{})["PolicyDocument"])
+		if strings.Contains(string(document), `"Action":"*"`) || strings.Contains(string(document), `"ec2:*"`) {
+			t.Errorf("policy %s has a wildcard action : %s", id, document)
+		}
+	}
+
+	clusterRole, _ := json.Marshal(roleByName(t, template, "TestCluster01ClusterRole"))
+	for _, policy := range []string{"AmazonEKSClusterPolicy", "AmazonEKSVPCResourceController"} {
+		if !strings.Contains(string(clusterRole), policy) {
+			t.Errorf("cluster role without %s : %s", policy, clusterRole)
+		}
+	}
+	if strings.Contains(string(clusterRole), "AmazonEKSWorkerNodePolicy") {
+		t.Errorf("cluster role with the node policies : %s", clusterRole)
+	}
+
+	nodeRole, _ := json.Marshal(roleByName(t, template, "TestCluster01NodeRole"))
+	if !strings.Contains(string(nodeRole), "ec2.amazonaws.com") || strings.Contains(string(nodeRole), "AmazonEKSClusterPolicy") {
+		t.Errorf("unexpected node role : %s", nodeRole)
+	}
+	// The admin role has no managed policy
+	if policies := roleByName(t, template, "TestCluster01AdminRole")["ManagedPolicyArns"]; policies != nil {
+		t.Errorf("admin role with managed policies : %v", policies)
+	}
+}
+
+func TestEksRolesAssignment(t *testing.T) {
+	template := testEksStack(testConfig())
+
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"roleArn": map[string]interface{}{"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^ClusterRole")), "Arn"}},
+		}),
+	})
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"NodeRole": map[string]interface{}{"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^NodeRole")), "Arn"}},
+	})
+}
+
+func TestEksAdminPrincipals(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.AdminPrincipals = []string{"arn:aws:iam::123456789012:role/Operators"}
+	template := testEksStack(AppConfig)
+
+	trust, _ := json.Marshal(roleByName(t, template, "TestCluster01AdminRole")["AssumeRolePolicyDocument"])
+	for _, principal := range []string{"arn:aws:iam::123456789012:user/deployer", "arn:aws:iam::123456789012:role/Operators"} {
+		if !strings.Contains(string(trust), principal) {
+			t.Errorf("admin role not trusted by %s : %s", principal, trust)
+		}
+	}
+	if strings.Contains(string(trust), "eks.amazonaws.com") {
+		t.Errorf("admin role trusted by EKS : %s", trust)
+	}
+}
+
+func TestEksAccessEntries(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.AuthenticationMode = "API_AND_CONFIG_MAP"
+	AppConfig.AccessEntries = []AccessEntry{{
+		PrincipalArn: "arn:aws:iam::123456789012:role/BuildAdminRole01",
+		Policy:       "AmazonEKSViewPolicy",
+		Namespaces:   []string{"sonarqube"},
+	}}
+	template := testEksStack(AppConfig)
+
+	// The cluster is created in CONFIG_MAP, then updated to the mode before the access entries
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"accessConfig": assertions.Match_Absent(),
+		}),
+	})
+	template.ResourceCountIs(jsii.String("Custom::AWS"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("Custom::AWS"), map[string]interface{}{
+		"Update": assertions.Match_SerializedJson(assertions.Match_ObjectLike(&map[string]interface{}{
+			"action":                   "updateClusterConfig",
+			"parameters":               map[string]interface{}{"name": "TestCluster01", "accessConfig": map[string]interface{}{"authenticationMode": "API_AND_CONFIG_MAP"}},
+			"ignoreErrorCodesMatching": assertions.Match_Absent(),
+		})),
+	})
+	template.AllResources(jsii.String("AWS::EKS::AccessEntry"), map[string]interface{}{
+		"DependsOn": assertions.Match_ArrayWith(&[]interface{}{assertions.Match_StringLikeRegexp(jsii.String("^AuthenticationMode"))}),
+	})
+	template.ResourceCountIs(jsii.String("AWS::EKS::AccessEntry"), jsii.Number(2))
+	template.HasResourceProperties(jsii.String("AWS::EKS::AccessEntry"), map[string]interface{}{
+		"ClusterName":  "TestCluster01",
+		"PrincipalArn": map[string]interface{}{"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^TestCluster01AdminRole")), "Arn"}},
+		"AccessPolicies": []interface{}{map[string]interface{}{
+			"PolicyArn":   map[string]interface{}{"Fn::Join": []interface{}{"", []interface{}{"arn:", map[string]interface{}{"Ref": "AWS::Partition"}, ":eks::aws:cluster-access-policy/AmazonEKSClusterAdminPolicy"}}},
+			"AccessScope": map[string]interface{}{"Type": "cluster"},
+		}},
+	})
+	template.HasResourceProperties(jsii.String("AWS::EKS::AccessEntry"), map[string]interface{}{
+		"PrincipalArn": "arn:aws:iam::123456789012:role/BuildAdminRole01",
+		"AccessPolicies": []interface{}{map[string]interface{}{
+			"PolicyArn":   assertions.Match_AnyValue(),
+			"AccessScope": map[string]interface{}{"Type": "namespace", "Namespaces": []interface{}{"sonarqube"}},
+		}},
+	})
+}
+
+func TestEksWithoutAccessEntries(t *testing.T) {
+	template := testEksStack(testConfig())
+
+	template.ResourceCountIs(jsii.String("AWS::EKS::AccessEntry"), jsii.Number(0))
+}
+
+func TestEksPrivateEndpoint(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.EndpointAccess = "private"
+	AppConfig.Bastion = true
+	template := testEksStack(AppConfig)
+
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"resourcesVpcConfig": assertions.Match_ObjectLike(&map[string]interface{}{
+				"endpointPublicAccess":  false,
+				"endpointPrivateAccess": true,
+			}),
+		}),
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::Instance"), map[string]interface{}{
+		"Tags": assertions.Match_ArrayWith(&[]interface{}{
+			map[string]interface{}{"Key": "Name", "Value": "TestCluster01-bastion"},
+		}),
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroupIngress"), map[string]interface{}{
+		"FromPort": 443,
+		"ToPort":   443,
+		"SourceSecurityGroupId": map[string]interface{}{
+			"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^BastionInstanceSecurityGroup")), "GroupId"},
+		},
+	})
+
+	AppConfig.PublicAccessCidrs = []string{"203.0.113.0/24"}
+	if _, err := endpointAccess(AppConfig); err == nil {
+		t.Error("PublicAccessCidrs accepted with a private endpoint")
+	}
+}
+
+func TestEksVersions(t *testing.T) {
+	AppConfig := testConfig()
+	for _, version := range k8sversions.Supported() {
+		AppConfig.K8sVersion = version
+		if _, err := clusterVersion(AppConfig); err != nil {
+			t.Error(err)
+		}
+	}
+	AppConfig.K8sVersion = "1.25"
+	if _, err := clusterVersion(AppConfig); err == nil {
+		t.Error("K8sVersion 1.25 accepted without a compatible kubectl layer")
+	}
+}
+
+func TestEksSecretsEncryption(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.SecretsEncryption = true
+	template := testEksStack(AppConfig)
+
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"encryptionConfig": []interface{}{
+				map[string]interface{}{
+					"provider":  map[string]interface{}{"keyArn": map[string]interface{}{"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^SecretsKey")), "Arn"}}},
+					"resources": []interface{}{"secrets"},
+				},
+			},
+		}),
+	})
+	template.HasResourceProperties(jsii.String("AWS::KMS::Key"), map[string]interface{}{
+		"EnableKeyRotation": true,
+		"KeyPolicy": map[string]interface{}{
+			"Statement": assertions.Match_ArrayWith(&[]interface{}{
+				map[string]interface{}{
+					"Effect":    "Allow",
+					"Principal": map[string]interface{}{"AWS": map[string]interface{}{"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^ClusterRole")), "Arn"}}},
+					"Action":    []interface{}{"kms:Encrypt", "kms:Decrypt", "kms:DescribeKey", "kms:CreateGrant"},
+					"Resource":  "*",
+				},
+			}),
+		},
+	})
+	template.HasResourceProperties(jsii.String("AWS::KMS::Alias"), map[string]interface{}{
+		"AliasName": "alias/TestCluster01-secrets",
+	})
+}
+
+func TestEksSecretsKeyArn(t *testing.T) {
+	keyArn := "arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	AppConfig := testConfig()
+	AppConfig.SecretsKeyArn = keyArn
+	template := testEksStack(AppConfig)
+
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"encryptionConfig": []interface{}{
+				map[string]interface{}{"provider": map[string]interface{}{"keyArn": keyArn}, "resources": []interface{}{"secrets"}},
+			},
+		}),
+	})
+	template.ResourceCountIs(jsii.String("AWS::KMS::Key"), jsii.Number(0))
+
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"), map[string]interface{}{
+		"PolicyDocument": map[string]interface{}{
+			"Statement": assertions.Match_ArrayWith(&[]interface{}{
+				map[string]interface{}{
+					"Effect":   "Allow",
+					"Action":   []interface{}{"kms:Encrypt", "kms:Decrypt", "kms:DescribeKey", "kms:CreateGrant"},
+					"Resource": keyArn,
+				},
+			}),
+		},
+		"Roles": []interface{}{map[string]interface{}{"Ref": assertions.Match_StringLikeRegexp(jsii.String("^ClusterRole"))}},
+	})
+
+	AppConfig.SecretsKeyArn = "alias/secrets"
+	if _, err := secretsKey(awscdk.NewStack(awscdk.NewApp(nil), jsii.String("Test"), nil), "TestCluster01", nil, AppConfig); err == nil {
+		t.Error("invalid SecretsKeyArn accepted")
+	}
+}
+
+func TestKmsKeyArn(t *testing.T) {
+	for keyArn, valid := range map[string]bool{
+		"arn:aws:kms:eu-west-1:123456789012:key/1234abcd-12ab-34cd-56ef-1234567890ab":     true,
+		"arn:aws:kms:eu-west-1:123456789012:key/mrk-1234abcd12ab34cd56ef1234567890ab":     true,
+		"arn:aws-cn:kms:cn-north-1:123456789012:key/mrk-1234abcd12ab34cd56ef1234567890ab": true,
+		"arn:aws:kms:eu-west-1:123456789012:key/mrk-":                                     false,
+		"arn:aws:kms:eu-west-1:123456789012:alias/secrets":                                false,
+	} {
+		if kmsKeyArn.MatchString(keyArn) != valid {
+			t.Errorf("%s : valid %v expected", keyArn, valid)
+		}
+	}
+}
+
+func TestEksControlPlaneLogs(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.ControlPlaneLogs = []string{"api", "audit", "authenticator"}
+	template := testEksStack(AppConfig)
+
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-Cluster"), map[string]interface{}{
+		"Config": assertions.Match_ObjectLike(&map[string]interface{}{
+			"logging": map[string]interface{}{
+				"clusterLogging": []interface{}{
+					map[string]interface{}{"enabled": true, "types": []interface{}{"api", "audit", "authenticator"}},
+				},
+			},
+		}),
+	})
+
+	AppConfig.ControlPlaneLogs = []string{"controller-manager"}
+	if _, err := controlPlaneLogging(AppConfig); err == nil {
+		t.Error("unknown log type accepted")
+	}
+}
+
+func TestEksDefaultNodegroup(t *testing.T) {
+	template := testEksStack(testConfig())
+
+	// Same logical ID as the default capacity of the cluster : the nodegroup is not replaced
+	nodegroups := *template.FindResources(jsii.String("AWS::EKS::Nodegroup"), nil)
+	if len(nodegroups) != 1 {
+		t.Fatalf("%d nodegroups instead of 1", len(nodegroups))
+	}
+	for id := range nodegroups {
+		if !strings.HasPrefix(id, "TestCluster01NodegroupDefaultCapacity") {
+			t.Errorf("default nodegroup with the logical ID %s", id)
+		}
+	}
+	template.HasResourceProperties(jsii.String("AWS::EKS::Nodegroup"), map[string]interface{}{
+		"InstanceTypes": []interface{}{"t4g.xlarge"},
+		"ScalingConfig": map[string]interface{}{"MinSize": 2, "MaxSize": 2, "DesiredSize": 2},
+		"Labels":        map[string]interface{}{"role": "worker"},
+	})
+
+	AppConfig := testConfig()
+	AppConfig.Workernode = 0
+	testEksStack(AppConfig).ResourceCountIs(jsii.String("AWS::EKS::Nodegroup"), jsii.Number(0))
+}
+
+func TestNodegroupOptions(t *testing.T) {
+	group := NodeGroup{
+		Name:          "builds",
+		InstanceTypes: []string{"m6i.large", "m5.large"},
+		CapacityType:  "spot",
+		DiskSize:      50,
+		AmiType:       "BOTTLEROCKET_x86_64",
+		Labels:        map[string]string{"role": "builds"},
+		Taints:        []Taint{{Key: "workload", Value: "builds", Effect: "NoSchedule"}},
+	}
+	options, err := nodegroupOptions(group, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*options.InstanceTypes) != 2 || options.CapacityType != awseks.CapacityType_SPOT || options.AmiType != awseks.NodegroupAmiType_BOTTLEROCKET_X86_64 {
+		t.Errorf("unexpected options : %v %v %v", *options.InstanceTypes, options.CapacityType, options.AmiType)
+	}
+	if options.DiskSize == nil || *options.DiskSize != 50 {
+		t.Errorf("disk size not set without launch template : %v", options.DiskSize)
+	}
+	if *(*options.Labels)["role"] != "builds" || len(*options.Taints) != 1 || (*options.Taints)[0].Effect != awseks.TaintEffect_NO_SCHEDULE {
+		t.Errorf("unexpected labels or taints : %v %v", *options.Labels, *options.Taints)
+	}
+
+	// The disk size is set in the launch template
+	if options, _ := nodegroupOptions(group, true); options.DiskSize != nil {
+		t.Errorf("disk size set with a launch template : %v", *options.DiskSize)
+	}
+
+	for _, invalid := range []NodeGroup{
+		{InstanceTypes: []string{"t3.large"}},
+		{Name: "tools"},
+		{Name: "tools", InstanceTypes: []string{"t3.large"}, CapacityType: "reserved"},
+		{Name: "tools", InstanceTypes: []string{"t3.large"}, AmiType: "WINDOWS_CORE_2022_x86_64"},
+		{Name: "tools", InstanceTypes: []string{"t3.large"}, Taints: []Taint{{Key: "workload", Effect: "NoRun"}}},
+	} {
+		if _, err := nodegroupOptions(invalid, false); err == nil {
+			t.Errorf("invalid node group accepted : %+v", invalid)
+		}
+	}
+}
+
+func TestNodeLaunchTemplate(t *testing.T) {
+	stack := awscdk.NewStack(awscdk.NewApp(nil), jsii.String("Test"), nil)
+	securityGroupIds := []*string{jsii.String("sg-0123456789")}
+	nodeLaunchTemplate(stack, "toolsLaunchTemplate", securityGroupIds, NodeGroup{Name: "tools", DiskSize: 50, AmiType: "AL2_ARM_64"})
+	nodeLaunchTemplate(stack, "buildsLaunchTemplate", securityGroupIds, NodeGroup{Name: "builds", DiskSize: 100, AmiType: "BOTTLEROCKET_x86_64"})
+	nodeLaunchTemplate(stack, "appsLaunchTemplate", securityGroupIds, NodeGroup{Name: "apps"})
+	template := assertions.Template_FromStack(stack, nil)
+
+	// Root volume, or data volume for Bottlerocket
+	for deviceName, size := range map[string]int{"/dev/xvda": 50, "/dev/xvdb": 100} {
+		template.HasResourceProperties(jsii.String("AWS::EC2::LaunchTemplate"), map[string]interface{}{
+			"LaunchTemplateData": assertions.Match_ObjectLike(&map[string]interface{}{
+				"SecurityGroupIds": []interface{}{"sg-0123456789"},
+				"BlockDeviceMappings": []interface{}{map[string]interface{}{
+					"DeviceName": deviceName,
+					"Ebs":        map[string]interface{}{"VolumeSize": size, "VolumeType": "gp3"},
+				}},
+				"MetadataOptions": map[string]interface{}{"HttpTokens": "required", "HttpPutResponseHopLimit": 2},
+			}),
+		})
+	}
+	template.HasResourceProperties(jsii.String("AWS::EC2::LaunchTemplate"), map[string]interface{}{
+		"LaunchTemplateData": assertions.Match_ObjectLike(&map[string]interface{}{
+			"BlockDeviceMappings": assertions.Match_Absent(),
+		}),
+	})
+}
+
+func TestEksKarpenter(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.Autoscaler = "karpenter"
+	AppConfig.AuthenticationMode = "API"
+	AppConfig.Karpenter = Karpenter{NodePools: []NodePool{{Name: "default", CapacityTypes: []string{"spot"}}}}
+	template := testEksStack(AppConfig)
+
+	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
+		"QueueName":              "TestCluster01-karpenter",
+		"MessageRetentionPeriod": 300,
+		"SqsManagedSseEnabled":   true,
+	})
+	template.ResourceCountIs(jsii.String("AWS::Events::Rule"), jsii.Number(4))
+	for _, detailType := range []string{"AWS Health Event", "EC2 Spot Instance Interruption Warning", "EC2 Instance Rebalance Recommendation", "EC2 Instance State-change Notification"} {
+		template.HasResourceProperties(jsii.String("AWS::Events::Rule"), map[string]interface{}{
+			"EventPattern": assertions.Match_ObjectLike(&map[string]interface{}{"detail-type": []interface{}{detailType}}),
+			"Targets": []interface{}{assertions.Match_ObjectLike(&map[string]interface{}{
+				"Arn": map[string]interface{}{"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^KarpenterInterruptionQueue")), "Arn"}},
+			})},
+		})
+	}
+
+	// Controller policy : scoped to the instances of the cluster, the node role and the queue
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"), map[string]interface{}{
+		"PolicyDocument": map[string]interface{}{
+			"Statement": assertions.Match_ArrayWith(&[]interface{}{
+				assertions.Match_ObjectLike(&map[string]interface{}{
+					"Sid":       "AllowScopedDeletion",
+					"Condition": assertions.Match_ObjectLike(&map[string]interface{}{"StringEquals": map[string]interface{}{"aws:ResourceTag/kubernetes.io/cluster/TestCluster01": "owned"}}),
+				}),
+				assertions.Match_ObjectLike(&map[string]interface{}{
+					"Sid":      "AllowInterruptionQueueActions",
+					"Resource": map[string]interface{}{"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^KarpenterInterruptionQueue")), "Arn"}},
+				}),
+				assertions.Match_ObjectLike(&map[string]interface{}{
+					"Sid":      "AllowPassingInstanceRole",
+					"Resource": map[string]interface{}{"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^KarpenterNodeRole")), "Arn"}},
+				}),
+			}),
+		},
+	})
+	template.HasResourceProperties(jsii.String("Custom::AWSCDK-EKS-HelmChart"), map[string]interface{}{
+		"Chart":      "karpenter",
+		"Repository": karpenterChartRepo,
+		"Version":    karpenterDefaultVersion,
+		"Namespace":  "kube-system",
+	})
+	// The manifest of the NodePool is joined with the role name and the subnets
+	manifests, _ := json.Marshal(template.FindResources(jsii.String("Custom::AWSCDK-EKS-KubernetesResource"), nil))
+	for _, kind := range []string{"EC2NodeClass", "NodePool"} {
+		if !strings.Contains(string(manifests), `\"kind\":\"`+kind+`\",\"metadata\":{\"name\":\"default\"`) {
+			t.Errorf("%s default not found : %s", kind, manifests)
+		}
+	}
+
+	// With AuthenticationMode API, the nodes join with an EC2_LINUX access entry instead of aws-auth
+	awsAuth, _ := json.Marshal(template.FindResources(jsii.String("Custom::AWSCDK-EKS-KubernetesResource"), map[string]interface{}{
+		"Properties": map[string]interface{}{"Overwrite": true},
+	}))
+	if strings.Contains(string(awsAuth), "KarpenterNodeRole") {
+		t.Errorf("Karpenter node role mapped in aws-auth : %s", awsAuth)
+	}
+	template.HasResource(jsii.String("AWS::EKS::AccessEntry"), map[string]interface{}{
+		"Properties": map[string]interface{}{
+			"ClusterName":  "TestCluster01",
+			"Type":         "EC2_LINUX",
+			"PrincipalArn": map[string]interface{}{"Fn::GetAtt": []interface{}{assertions.Match_StringLikeRegexp(jsii.String("^KarpenterNodeRole")), "Arn"}},
+		},
+		"DependsOn": assertions.Match_ArrayWith(&[]interface{}{assertions.Match_StringLikeRegexp(jsii.String("^AuthenticationMode"))}),
+	})
+
+	// With the aws-auth ConfigMap only, the node role is mapped without access entry
+	AppConfig.AuthenticationMode = ""
+	template = testEksStack(AppConfig)
+	template.ResourceCountIs(jsii.String("AWS::EKS::AccessEntry"), jsii.Number(0))
+	awsAuth, _ = json.Marshal(template.FindResources(jsii.String("Custom::AWSCDK-EKS-KubernetesResource"), map[string]interface{}{
+		"Properties": map[string]interface{}{"Overwrite": true},
+	}))
+	if !strings.Contains(string(awsAuth), "KarpenterNodeRole") {
+		t.Errorf("Karpenter node role not mapped in aws-auth : %s", awsAuth)
+	}
+}
+
+func TestKarpenterNodePool(t *testing.T) {
+	stack := awscdk.NewStack(awscdk.NewApp(nil), jsii.String("Test"), nil)
+	nodeRole := awsiam.NewRole(stack, jsii.String("NodeRole"), &awsiam.RoleProps{AssumedBy: awsiam.NewServicePrincipal(jsii.String("ec2.amazonaws.com"), nil)})
+	pool := NodePool{
+		Name:          "builds",
+		Architectures: []string{"arm64"},
+		AmiFamily:     "Bottlerocket",
+		Labels:        map[string]string{"role": "builds"},
+		Taints:        []Taint{{Key: "workload", Value: "builds", Effect: "NoSchedule"}},
+	}
+	nodePool, nodeClass, err := karpenterNodePool(pool, nodeRole, []*string{jsii.String("subnet-1")}, jsii.String("sg-1"), "TestCluster01")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	classSpec := nodeClass["spec"].(map[string]interface{})
+	if classSpec["amiFamily"] != "Bottlerocket" || len(classSpec["subnetSelectorTerms"].([]interface{})) != 1 {
+		t.Errorf("unexpected EC2NodeClass : %v", classSpec)
+	}
+	spec := nodePool["spec"].(map[string]interface{})
+	template := spec["template"].(map[string]interface{})
+	requirements, _ := json.Marshal(template["spec"].(map[string]interface{})["requirements"])
+	for _, requirement := range []string{`"values":["on-demand"]`, `"values":["arm64"]`, `"values":["c","m","r"]`} {
+		if !strings.Contains(string(requirements), requirement) {
+			t.Errorf("requirement %s not found : %s", requirement, requirements)
+		}
+	}
+	if spec["limits"].(map[string]interface{})["cpu"] != "100" || template["spec"].(map[string]interface{})["taints"] == nil {
+		t.Errorf("unexpected NodePool : %v", spec)
+	}
+
+	for _, invalid := range []NodePool{
+		{},
+		{Name: "builds", AmiFamily: "Ubuntu"},
+		{Name: "builds", Taints: []Taint{{Key: "workload", Effect: "NoRun"}}},
+	} {
+		if _, _, err := karpenterNodePool(invalid, nodeRole, nil, jsii.String("sg-1"), "TestCluster01"); err == nil {
+			t.Errorf("invalid NodePool accepted : %+v", invalid)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// kmsKeyArn is the ARN of a key, or of a replica of a multi-Region key (key/mrk-<ID>)
+var kmsKeyArn = regexp.MustCompile(`^arn:aws[a-z-]*:kms:[a-z0-9-]+:\d{12}:key/(mrk-[0-9a-f]{32}|[0-9a-f-]+)$`)
+
+// secretsKeyActions are the actions of the cluster role on the key of the secrets, EKS encrypts
+// the data keys of the secrets with grants created at the creation of the cluster
+var secretsKeyActions = []*string{
+	jsii.String("kms:Encrypt"),
+	jsii.String("kms:Decrypt"),
+	jsii.String("kms:DescribeKey"),
+	jsii.String("kms:CreateGrant"),
+}
+
+// secretsKey returns the KMS key of the envelope encryption of the Kubernetes secrets : the key
+// SecretsKeyArn, or a key of the stack with SecretsEncryption (nil without encryption)
+func secretsKey(stack awscdk.Stack, clusterName string, clusterRole awsiam.Role, AppConfig Configuration) (awskms.IKey, error) {
+	if AppConfig.SecretsKeyArn != "" {
+		if !kmsKeyArn.MatchString(AppConfig.SecretsKeyArn) {
+			return nil, fmt.Errorf("invalid SecretsKeyArn %q : arn:aws:kms:<Region>:<Account>:key/<KeyId>", AppConfig.SecretsKeyArn)
+		}
+		// The key policy is not managed by the stack, it must allow the IAM policies of the account
+		key := awskms.Key_FromKeyArn(stack, jsii.String("SecretsKey"), jsii.String(AppConfig.SecretsKeyArn))
+		clusterRole.AddToPrincipalPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Effect:    awsiam.Effect_ALLOW,
+			Actions:   &secretsKeyActions,
+			Resources: &[]*string{key.KeyArn()},
+		}))
+		return key, nil
+	}
+	if !AppConfig.SecretsEncryption {
+		return nil, nil
+	}
+
+	key := awskms.NewKey(stack, jsii.String("SecretsKey"), &awskms.KeyProps{
+		Alias:             jsii.String("alias/" + clusterName + "-secrets"),
+		Description:       jsii.String("Envelope encryption of the Kubernetes secrets of " + clusterName),
+		EnableKeyRotation: jsii.Bool(true),
+		RemovalPolicy:     awscdk.RemovalPolicy_RETAIN,
+	})
+	key.AddToResourcePolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Effect:     awsiam.Effect_ALLOW,
+		Principals: &[]awsiam.IPrincipal{clusterRole},
+		Actions:    &secretsKeyActions,
+		Resources:  &[]*string{jsii.String("*")},
+	}), nil)
+	return key, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// endpointAccess returns the access to the API endpoint of the cluster : public (default), private
+// or public-and-private, the public endpoint restricted to PublicAccessCidrs when set
+func endpointAccess(AppConfig Configuration) (awseks.EndpointAccess, error) {
+	var access awseks.EndpointAccess
+	switch AppConfig.EndpointAccess {
+	case "", "public":
+		access = awseks.EndpointAccess_PUBLIC()
+	case "public-and-private":
+		access = awseks.EndpointAccess_PUBLIC_AND_PRIVATE()
+	case "private":
+		if len(AppConfig.PublicAccessCidrs) > 0 {
+			return nil, fmt.Errorf("PublicAccessCidrs can't be set with a private EndpointAccess")
+		}
+		return awseks.EndpointAccess_PRIVATE(), nil
+	default:
+		return nil, fmt.Errorf("unknown EndpointAccess %q : public, private or public-and-private", AppConfig.EndpointAccess)
+	}
+
+	if len(AppConfig.PublicAccessCidrs) > 0 {
+		var cidrs []*string
+		for _, cidr := range AppConfig.PublicAccessCidrs {
+			cidrs = append(cidrs, jsii.String(cidr))
+		}
+		access = access.OnlyFrom(cidrs...)
+	}
+	return access, nil
+}
+
+// addBastion creates the bastion of the private endpoint : an instance in the private subnets
+// without inbound rule, reached with SSM Session Manager (port forwarding to the endpoint)
+func addBastion(stack awscdk.Stack, vpc awsec2.IVpc, cluster awseks.Cluster, clusterName string) awsec2.BastionHostLinux {
+	bastion := awsec2.NewBastionHostLinux(stack, jsii.String("Bastion"), &awsec2.BastionHostLinuxProps{
+		Vpc:           vpc,
+		InstanceName:  jsii.String(clusterName + "-bastion"),
+		InstanceType:  awsec2.NewInstanceType(jsii.String("t3.micro")),
+		RequireImdsv2: jsii.Bool(true),
+		SubnetSelection: &awsec2.SubnetSelection{
+			SubnetType: awsec2.SubnetType_PRIVATE_WITH_EGRESS,
+		},
+	})
+
+	// The private endpoint is in the cluster security group
+	cluster.Connections().AllowFrom(bastion, awsec2.Port_Tcp(jsii.Number(443)), jsii.String("Kubernetes API from the bastion"))
+
+	awscdk.NewCfnOutput(stack, jsii.String("BastionInstanceId"), &awscdk.CfnOutputProps{
+		Value:       bastion.InstanceId(),
+		Description: jsii.String("SSM port forwarding to the private endpoint : eks/tunnel.sh"),
+	})
+	return bastion
+}
@@ -1,8 +1,9 @@
 module eks
 
-go 1.18
+go 1.21.1
 
 require (
+	CDK/pkg/k8sversions v1.0.0
 	github.com/aws/aws-cdk-go/awscdk/v2 v2.101.1
 	github.com/aws/constructs-go/constructs/v10 v10.2.70
 	github.com/aws/jsii-runtime-go v1.89.0
@@ -18,3 +19,5 @@ require (
 	github.com/cdklabs/awscdk-kubectl-go/kubectlv28/v2 v2.0.0 // indirect
 	github.com/jmespath/go-jmespath v0.4.0 // indirect
 )
+
+replace CDK/pkg/k8sversions v1.0.0 => ../pkg/k8sversions
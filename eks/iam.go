@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// clusterRole creates the service role of the EKS control plane
+func clusterRole(stack awscdk.Stack, clusterName string) awsiam.Role {
+	return awsiam.NewRole(stack, jsii.String("ClusterRole"), &awsiam.RoleProps{
+		RoleName:    jsii.String(clusterName + "ClusterRole"),
+		Description: jsii.String("EKS control plane of " + clusterName),
+		AssumedBy:   awsiam.NewServicePrincipal(jsii.String("eks.amazonaws.com"), nil),
+		ManagedPolicies: &[]awsiam.IManagedPolicy{
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKSClusterPolicy")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKSVPCResourceController")),
+		},
+	})
+}
+
+// nodeRole creates the role of the managed node groups : join the cluster, pod networking,
+// pull the images from ECR and send the logs and metrics to CloudWatch
+func nodeRole(stack awscdk.Stack, clusterName string) awsiam.Role {
+	return awsiam.NewRole(stack, jsii.String("NodeRole"), &awsiam.RoleProps{
+		RoleName:    jsii.String(clusterName + "NodeRole"),
+		Description: jsii.String("EKS managed node groups of " + clusterName),
+		AssumedBy:   awsiam.NewServicePrincipal(jsii.String("ec2.amazonaws.com"), nil),
+		ManagedPolicies: &[]awsiam.IManagedPolicy{
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKSWorkerNodePolicy")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKS_CNI_Policy")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEC2ContainerRegistryReadOnly")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("CloudWatchAgentServerPolicy")),
+		},
+	})
+}
+
+// adminRole creates the role of the cluster administrators (system:masters) : the caller of the
+// deployment and the AdminPrincipals of the configuration. It only needs to describe the cluster
+// for the kubeconfig, the Kubernetes permissions come from the cluster
+func adminRole(stack awscdk.Stack, roleName string, clusterName string, callerArn string, AppConfig Configuration) awsiam.Role {
+	principals := []awsiam.IPrincipal{awsiam.NewArnPrincipal(&callerArn)}
+	for _, arn := range AppConfig.AdminPrincipals {
+		principals = append(principals, awsiam.NewArnPrincipal(jsii.String(arn)))
+	}
+
+	role := awsiam.NewRole(stack, &roleName, &awsiam.RoleProps{
+		RoleName:    &roleName,
+		Description: jsii.String("Administrators of the EKS cluster " + clusterName),
+		AssumedBy:   awsiam.NewCompositePrincipal(principals...),
+	})
+	role.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Effect:    awsiam.Effect_ALLOW,
+		Actions:   &[]*string{jsii.String("eks:DescribeCluster")},
+		Resources: &[]*string{jsii.String(fmt.Sprintf("arn:%s:eks:%s:%s:cluster/%s", *stack.Partition(), *stack.Region(), *stack.Account(), clusterName))},
+	}))
+	role.AddToPolicy(awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+		Effect:    awsiam.Effect_ALLOW,
+		Actions:   &[]*string{jsii.String("eks:ListClusters")},
+		Resources: &[]*string{jsii.String("*")},
+	}))
+	return role
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsiam"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssqs"
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// Karpenter chart : https://github.com/aws/karpenter
+const (
+	karpenterChartRepo      = "oci://public.ecr.aws/karpenter/karpenter"
+	karpenterDefaultVersion = "v0.33.1"
+	karpenterNamespace      = "kube-system"
+	karpenterServiceAccount = "karpenter"
+)
+
+// Karpenter configuration : chart version and NodePools
+type Karpenter struct {
+	Version   string
+	NodePools []NodePool
+}
+
+// NodePool of Karpenter with its EC2NodeClass (same name)
+type NodePool struct {
+	Name               string
+	CapacityTypes      []string // spot, on-demand (default on-demand)
+	Architectures      []string // amd64, arm64 (default amd64)
+	InstanceCategories []string // c, m, r ... (default c, m, r)
+	AmiFamily          string   // AL2 (default), AL2023 or Bottlerocket
+	CpuLimit           string   // maximum CPU of the NodePool nodes (100)
+	Labels             map[string]string
+	Taints             []Taint
+}
+
+// karpenterNodeRole creates the role of the nodes launched by Karpenter : mapped in aws-auth (not
+// read with AuthenticationMode API), with an EC2_LINUX access entry when the access entries are
+// enabled (authentication is the dependency of the access entries, nil without access entries)
+func karpenterNodeRole(stack awscdk.Stack, cluster awseks.Cluster, clusterName string, authenticationMode string, authentication constructs.IDependable) (awsiam.Role, error) {
+	nodeRole := awsiam.NewRole(stack, jsii.String("KarpenterNodeRole"), &awsiam.RoleProps{
+		RoleName:  jsii.String(clusterName + "-KarpenterNodeRole"),
+		AssumedBy: awsiam.NewServicePrincipal(jsii.String("ec2.amazonaws.com"), nil),
+		ManagedPolicies: &[]awsiam.IManagedPolicy{
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKSWorkerNodePolicy")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEKS_CNI_Policy")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonEC2ContainerRegistryReadOnly")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("AmazonSSMManagedInstanceCore")),
+			awsiam.ManagedPolicy_FromAwsManagedPolicyName(jsii.String("CloudWatchAgentServerPolicy")),
+		},
+	})
+
+	if authenticationMode != "API" {
+		cluster.AwsAuth().AddRoleMapping(nodeRole, &awseks.AwsAuthMapping{
+			Username: jsii.String("system:node:{{EC2PrivateDNSName}}"),
+			Groups:   &[]*string{jsii.String("system:bootstrappers"), jsii.String("system:nodes")},
+		})
+	}
+	if authentication != nil {
+		accessEntry, err := addAccessEntry(stack, "KarpenterNodeAccessEntry", clusterName, AccessEntry{
+			PrincipalArn: *nodeRole.RoleArn(),
+			Type:         "EC2_LINUX",
+		})
+		if err != nil {
+			return nil, err
+		}
+		accessEntry.Node().AddDependency(authentication)
+	}
+	return nodeRole, nil
+}
+
+// karpenterInterruptionQueue creates the SQS queue of the interruption events (spot interruption,
+// rebalance recommendation, instance state change, health events) and their EventBridge rules
+func karpenterInterruptionQueue(stack awscdk.Stack, clusterName string) awssqs.Queue {
+	queue := awssqs.NewQueue(stack, jsii.String("KarpenterInterruptionQueue"), &awssqs.QueueProps{
+		QueueName:       jsii.String(clusterName + "-karpenter"),
+		RetentionPeriod: awscdk.Duration_Minutes(jsii.Number(5)),
+		Encryption:      awssqs.QueueEncryption_SQS_MANAGED,
+	})
+
+	rules := map[string]*awsevents.EventPattern{
+		"ScheduledChangeRule": {
+			Source:     &[]*string{jsii.String("aws.health")},
+			DetailType: &[]*string{jsii.String("AWS Health Event")},
+		},
+		"SpotInterruptionRule": {
+			Source:     &[]*string{jsii.String("aws.ec2")},
+			DetailType: &[]*string{jsii.String("EC2 Spot Instance Interruption Warning")},
+		},
+		"RebalanceRule": {
+			Source:     &[]*string{jsii.String("aws.ec2")},
+			DetailType: &[]*string{jsii.String("EC2 Instance Rebalance Recommendation")},
+		},
+		"InstanceStateChangeRule": {
+			Source:     &[]*string{jsii.String("aws.ec2")},
+			DetailType: &[]*string{jsii.String("EC2 Instance State-change Notification")},
+		},
+	}
+	for _, id := range []string{"ScheduledChangeRule", "SpotInterruptionRule", "RebalanceRule", "InstanceStateChangeRule"} {
+		awsevents.NewRule(stack, jsii.String("Karpenter"+id), &awsevents.RuleProps{
+			EventPattern: rules[id],
+			Targets:      &[]awsevents.IRuleTarget{awseventstargets.NewSqsQueue(queue, nil)},
+		})
+	}
+	return queue
+}
+
+// karpenterControllerPolicy returns the policy of the Karpenter controller : launch and terminate
+// the instances of the cluster, manage their instance profiles and read the interruption queue
+func karpenterControllerPolicy(stack awscdk.Stack, clusterName string, nodeRole awsiam.Role, queue awssqs.Queue) []awsiam.PolicyStatement {
+	region := stack.Region()
+	account := stack.Account()
+	ec2Arn := func(resource string) *string {
+		return jsii.String(fmt.Sprintf("arn:%s:ec2:%s:*:%s", *stack.Partition(), *region, resource))
+	}
+	clusterTag := "kubernetes.io/cluster/" + clusterName
+
+	return []awsiam.PolicyStatement{
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:     jsii.String("AllowScopedEC2InstanceActions"),
+			Actions: &[]*string{jsii.String("ec2:RunInstances"), jsii.String("ec2:CreateFleet")},
+			Resources: &[]*string{
+				jsii.String(fmt.Sprintf("arn:%s:ec2:%s::image/*", *stack.Partition(), *region)),
+				jsii.String(fmt.Sprintf("arn:%s:ec2:%s::snapshot/*", *stack.Partition(), *region)),
+				ec2Arn("spot-instances-request/*"), ec2Arn("security-group/*"), ec2Arn("subnet/*"), ec2Arn("launch-template/*"),
+			},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:     jsii.String("AllowScopedEC2InstanceActionsWithTags"),
+			Actions: &[]*string{jsii.String("ec2:RunInstances"), jsii.String("ec2:CreateFleet"), jsii.String("ec2:CreateLaunchTemplate")},
+			Resources: &[]*string{
+				ec2Arn("fleet/*"), ec2Arn("instance/*"), ec2Arn("volume/*"), ec2Arn("network-interface/*"), ec2Arn("launch-template/*"), ec2Arn("spot-instances-request/*"),
+			},
+			Conditions: &map[string]interface{}{
+				"StringEquals": map[string]interface{}{"aws:RequestTag/" + clusterTag: "owned"},
+				"StringLike":   map[string]interface{}{"aws:RequestTag/karpenter.sh/nodepool": "*"},
+			},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:     jsii.String("AllowScopedResourceCreationTagging"),
+			Actions: &[]*string{jsii.String("ec2:CreateTags")},
+			Resources: &[]*string{
+				ec2Arn("fleet/*"), ec2Arn("instance/*"), ec2Arn("volume/*"), ec2Arn("network-interface/*"), ec2Arn("launch-template/*"), ec2Arn("spot-instances-request/*"),
+			},
+			Conditions: &map[string]interface{}{
+				"StringEquals": map[string]interface{}{
+					"aws:RequestTag/" + clusterTag: "owned",
+					"ec2:CreateAction":             []string{"RunInstances", "CreateFleet", "CreateLaunchTemplate"},
+				},
+				"StringLike": map[string]interface{}{"aws:RequestTag/karpenter.sh/nodepool": "*"},
+			},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:       jsii.String("AllowScopedResourceTagging"),
+			Actions:   &[]*string{jsii.String("ec2:CreateTags")},
+			Resources: &[]*string{ec2Arn("instance/*")},
+			Conditions: &map[string]interface{}{
+				"StringEquals":              map[string]interface{}{"aws:ResourceTag/" + clusterTag: "owned"},
+				"StringLike":                map[string]interface{}{"aws:ResourceTag/karpenter.sh/nodepool": "*"},
+				"ForAllValues:StringEquals": map[string]interface{}{"aws:TagKeys": []string{"karpenter.sh/nodeclaim", "Name"}},
+			},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:       jsii.String("AllowScopedDeletion"),
+			Actions:   &[]*string{jsii.String("ec2:TerminateInstances"), jsii.String("ec2:DeleteLaunchTemplate")},
+			Resources: &[]*string{ec2Arn("instance/*"), ec2Arn("launch-template/*")},
+			Conditions: &map[string]interface{}{
+				"StringEquals": map[string]interface{}{"aws:ResourceTag/" + clusterTag: "owned"},
+				"StringLike":   map[string]interface{}{"aws:ResourceTag/karpenter.sh/nodepool": "*"},
+			},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid: jsii.String("AllowRegionalReadActions"),
+			Actions: &[]*string{
+				jsii.String("ec2:DescribeAvailabilityZones"),
+				jsii.String("ec2:DescribeImages"),
+				jsii.String("ec2:DescribeInstances"),
+				jsii.String("ec2:DescribeInstanceTypeOfferings"),
+				jsii.String("ec2:DescribeInstanceTypes"),
+				jsii.String("ec2:DescribeLaunchTemplates"),
+				jsii.String("ec2:DescribeSecurityGroups"),
+				jsii.String("ec2:DescribeSpotPriceHistory"),
+				jsii.String("ec2:DescribeSubnets"),
+			},
+			Resources: &[]*string{jsii.String("*")},
+			Conditions: &map[string]interface{}{
+				"StringEquals": map[string]interface{}{"aws:RequestedRegion": region},
+			},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:       jsii.String("AllowSSMReadActions"),
+			Actions:   &[]*string{jsii.String("ssm:GetParameter")},
+			Resources: &[]*string{jsii.String(fmt.Sprintf("arn:%s:ssm:%s::parameter/aws/service/*", *stack.Partition(), *region))},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:       jsii.String("AllowPricingReadActions"),
+			Actions:   &[]*string{jsii.String("pricing:GetProducts")},
+			Resources: &[]*string{jsii.String("*")},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:       jsii.String("AllowInterruptionQueueActions"),
+			Actions:   &[]*string{jsii.String("sqs:DeleteMessage"), jsii.String("sqs:GetQueueUrl"), jsii.String("sqs:ReceiveMessage")},
+			Resources: &[]*string{queue.QueueArn()},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:       jsii.String("AllowPassingInstanceRole"),
+			Actions:   &[]*string{jsii.String("iam:PassRole")},
+			Resources: &[]*string{nodeRole.RoleArn()},
+			Conditions: &map[string]interface{}{
+				"StringEquals": map[string]interface{}{"iam:PassedToService": "ec2.amazonaws.com"},
+			},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid: jsii.String("AllowScopedInstanceProfileActions"),
+			Actions: &[]*string{
+				jsii.String("iam:CreateInstanceProfile"),
+				jsii.String("iam:TagInstanceProfile"),
+				jsii.String("iam:AddRoleToInstanceProfile"),
+				jsii.String("iam:RemoveRoleFromInstanceProfile"),
+				jsii.String("iam:DeleteInstanceProfile"),
+			},
+			Resources: &[]*string{jsii.String(fmt.Sprintf("arn:%s:iam::%s:instance-profile/*", *stack.Partition(), *account))},
+			Conditions: &map[string]interface{}{
+				"StringEquals": map[string]interface{}{"aws:ResourceTag/" + clusterTag: "owned"},
+			},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:       jsii.String("AllowInstanceProfileReadActions"),
+			Actions:   &[]*string{jsii.String("iam:GetInstanceProfile")},
+			Resources: &[]*string{jsii.String("*")},
+		}),
+		awsiam.NewPolicyStatement(&awsiam.PolicyStatementProps{
+			Sid:       jsii.String("AllowAPIServerEndpointDiscovery"),
+			Actions:   &[]*string{jsii.String("eks:DescribeCluster")},
+			Resources: &[]*string{jsii.String(fmt.Sprintf("arn:%s:eks:%s:%s:cluster/%s", *stack.Partition(), *region, *account, clusterName))},
+		}),
+	}
+}
+
+// karpenterNodePool returns the NodePool and EC2NodeClass manifests of a NodePool of the configuration
+func karpenterNodePool(pool NodePool, nodeRole awsiam.Role, subnetIds []*string, securityGroupId *string, clusterName string) (map[string]interface{}, map[string]interface{}, error) {
+	if pool.Name == "" {
+		return nil, nil, fmt.Errorf("Karpenter NodePool : Name is required")
+	}
+	defaults := func(values []string, defaultValues ...string) []string {
+		if len(values) == 0 {
+			return defaultValues
+		}
+		return values
+	}
+
+	amiFamily := pool.AmiFamily
+	switch amiFamily {
+	case "":
+		amiFamily = "AL2"
+	case "AL2", "AL2023", "Bottlerocket":
+	default:
+		return nil, nil, fmt.Errorf("Karpenter NodePool %s : unknown AmiFamily %q : AL2, AL2023 or Bottlerocket", pool.Name, pool.AmiFamily)
+	}
+
+	var subnetSelectorTerms []interface{}
+	for _, subnetId := range subnetIds {
+		subnetSelectorTerms = append(subnetSelectorTerms, map[string]interface{}{"id": subnetId})
+	}
+	nodeClass := map[string]interface{}{
+		"apiVersion": "karpenter.k8s.aws/v1beta1",
+		"kind":       "EC2NodeClass",
+		"metadata":   map[string]interface{}{"name": pool.Name},
+		"spec": map[string]interface{}{
+			"amiFamily":                  amiFamily,
+			"role":                       nodeRole.RoleName(),
+			"subnetSelectorTerms":        subnetSelectorTerms,
+			"securityGroupSelectorTerms": []interface{}{map[string]interface{}{"id": securityGroupId}},
+			"tags":                       map[string]interface{}{"karpenter.sh/discovery": clusterName},
+		},
+	}
+
+	var taints []interface{}
+	for _, taint := range pool.Taints {
+		if _, ok := taintEffects[taint.Effect]; !ok {
+			return nil, nil, fmt.Errorf("Karpenter NodePool %s : unknown taint Effect %q : NoSchedule, PreferNoSchedule or NoExecute", pool.Name, taint.Effect)
+		}
+		taints = append(taints, map[string]interface{}{"key": taint.Key, "value": taint.Value, "effect": taint.Effect})
+	}
+
+	labels := make(map[string]interface{})
+	for key, value := range pool.Labels {
+		labels[key] = value
+	}
+	cpuLimit := pool.CpuLimit
+	if cpuLimit == "" {
+		cpuLimit = "100"
+	}
+
+	template := map[string]interface{}{
+		"nodeClassRef": map[string]interface{}{"name": pool.Name},
+		"requirements": []interface{}{
+			map[string]interface{}{"key": "karpenter.sh/capacity-type", "operator": "In", "values": defaults(pool.CapacityTypes, "on-demand")},
+			map[string]interface{}{"key": "kubernetes.io/arch", "operator": "In", "values": defaults(pool.Architectures, "amd64")},
+			map[string]interface{}{"key": "karpenter.k8s.aws/instance-category", "operator": "In", "values": defaults(pool.InstanceCategories, "c", "m", "r")},
+		},
+	}
+	if len(taints) > 0 {
+		template["taints"] = taints
+	}
+
+	nodePool := map[string]interface{}{
+		"apiVersion": "karpenter.sh/v1beta1",
+		"kind":       "NodePool",
+		"metadata":   map[string]interface{}{"name": pool.Name},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": labels},
+				"spec":     template,
+			},
+			"limits":     map[string]interface{}{"cpu": cpuLimit},
+			"disruption": map[string]interface{}{"consolidationPolicy": "WhenUnderutilized", "expireAfter": "720h"},
+		},
+	}
+	return nodePool, nodeClass, nil
+}
+
+// addKarpenter installs Karpenter in the cluster : node role, interruption queue, controller
+// role (IRSA), Helm chart, then the NodePools and EC2NodeClasses of the configuration
+func addKarpenter(stack awscdk.Stack, cluster awseks.Cluster, AppConfig Configuration, clusterName string, subnetIds []*string, authentication constructs.IDependable) error {
+	config := AppConfig.Karpenter
+	nodeRole, err := karpenterNodeRole(stack, cluster, clusterName, AppConfig.AuthenticationMode, authentication)
+	if err != nil {
+		return err
+	}
+	queue := karpenterInterruptionQueue(stack, clusterName)
+
+	serviceAccount := cluster.AddServiceAccount(jsii.String("KarpenterServiceAccount"), &awseks.ServiceAccountOptions{
+		Name:      jsii.String(karpenterServiceAccount),
+		Namespace: jsii.String(karpenterNamespace),
+	})
+	for _, statement := range karpenterControllerPolicy(stack, clusterName, nodeRole, queue) {
+		serviceAccount.AddToPrincipalPolicy(statement)
+	}
+
+	version := config.Version
+	if version == "" {
+		version = karpenterDefaultVersion
+	}
+	chart := cluster.AddHelmChart(jsii.String("Karpenter"), &awseks.HelmChartOptions{
+		Repository: jsii.String(karpenterChartRepo),
+		Chart:      jsii.String("karpenter"),
+		Release:    jsii.String("karpenter"),
+		Version:    &version,
+		Namespace:  jsii.String(karpenterNamespace),
+		Wait:       jsii.Bool(true),
+		Values: &map[string]interface{}{
+			"serviceAccount": map[string]interface{}{
+				"create": false,
+				"name":   karpenterServiceAccount,
+			},
+			"settings": map[string]interface{}{
+				"clusterName":       clusterName,
+				"clusterEndpoint":   cluster.ClusterEndpoint(),
+				"interruptionQueue": queue.QueueName(),
+			},
+		},
+	})
+	chart.Node().AddDependency(serviceAccount)
+
+	// The NodePool and EC2NodeClass CRDs are installed by the chart
+	for _, pool := range config.NodePools {
+		nodePool, nodeClass, err := karpenterNodePool(pool, nodeRole, subnetIds, cluster.ClusterSecurityGroupId(), clusterName)
+		if err != nil {
+			return err
+		}
+		manifest := cluster.AddManifest(jsii.String("KarpenterNodePool-"+pool.Name), &nodeClass, &nodePool)
+		manifest.Node().AddDependency(chart)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+#!/bin/sh
+# Access to a private API endpoint through the bastion of the EKS stack (SSM port forwarding).
+# The kubeconfig of the cluster points to the local port : the addons stack, sonarqube and
+# devops/gitdep.go use it unchanged while the tunnel is open.
+# Requires the AWS CLI with the Session Manager plugin and kubectl.
+
+if [ -z "$1" ]; then
+    echo "Usage: $0 <ClusterName><Index> [local port, default 8443]"
+    exit 1
+fi
+CLUSTER=$1
+PORT=${2:-8443}
+
+ENDPOINT=$(aws eks describe-cluster --name "$CLUSTER" --query cluster.endpoint --output text) || exit 1
+HOST=${ENDPOINT#https://}
+
+BASTION=$(aws ec2 describe-instances \
+    --filters "Name=tag:Name,Values=$CLUSTER-bastion" "Name=instance-state-name,Values=running" \
+    --query "Reservations[0].Instances[0].InstanceId" --output text)
+if [ -z "$BASTION" ] || [ "$BASTION" = "None" ]; then
+    echo "❌ No running bastion $CLUSTER-bastion : set Bastion to true in eks/config.json"
+    exit 1
+fi
+
+# API server on the local port, the certificate is checked against the name of the endpoint
+aws eks update-kubeconfig --name "$CLUSTER" || exit 1
+CONTEXT=$(kubectl config current-context)
+kubectl config set-cluster "$CONTEXT" --server="https://127.0.0.1:$PORT" --tls-server-name="$HOST" >/dev/null || exit 1
+
+echo "✅ kubeconfig $CONTEXT uses https://127.0.0.1:$PORT : keep this session open (Ctrl-C to stop)"
+echo "   To use the endpoint again : aws eks update-kubeconfig --name $CLUSTER"
+aws ssm start-session --target "$BASTION" \
+    --document-name AWS-StartPortForwardingSessionToRemoteHost \
+    --parameters "host=$HOST,portNumber=443,localPortNumber=$PORT"
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"CDK/pkg/k8sversions"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+)
+
+// ebsAddon is the add-on of the addons stack, its version is AddonVersion in config.json
+const ebsAddon = "aws-ebs-csi-driver"
+
+// pollInterval is the interval between the checks of an update in progress
+var pollInterval = 30 * time.Second
+
+// addonUpgrade is the upgrade of an add-on installed in the cluster
+type addonUpgrade struct {
+	Name    string
+	Current string
+	Target  string
+}
+
+// upgradePlan is what the upgrade changes, in the order of the upgrade
+type upgradePlan struct {
+	Cluster      string
+	Endpoint     string
+	Current      string
+	Target       string
+	Stack        k8sversions.Version
+	Nodegroups   []string // node groups not in the target version yet
+	Addons       []addonUpgrade
+	AddonVersion string            // EBS CSI driver version of the addons stack
+	Versions     map[string]string // target versions of the add-ons installed in the cluster, by EKS name
+}
+
+// planUpgrade checks the target version against the cluster and selects the versions of the
+// add-ons installed in the cluster
+func planUpgrade(svc eksiface.EKSAPI, clusterName string, target string) (*upgradePlan, error) {
+	cluster, err := svc.DescribeCluster(&eks.DescribeClusterInput{Name: aws.String(clusterName)})
+	if err != nil {
+		return nil, err
+	}
+	plan := &upgradePlan{
+		Cluster:  clusterName,
+		Endpoint: aws.StringValue(cluster.Cluster.Endpoint),
+		Current:  aws.StringValue(cluster.Cluster.Version),
+		Target:   target,
+		Stack:    k8sversions.Versions[target],
+		Versions: make(map[string]string),
+	}
+	if err := validateTarget(plan.Current, target); err != nil {
+		return nil, err
+	}
+
+	var nodegroups []string
+	err = svc.ListNodegroupsPages(&eks.ListNodegroupsInput{ClusterName: aws.String(clusterName)}, func(page *eks.ListNodegroupsOutput, lastPage bool) bool {
+		nodegroups = append(nodegroups, aws.StringValueSlice(page.Nodegroups)...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range nodegroups {
+		nodegroup, err := svc.DescribeNodegroup(&eks.DescribeNodegroupInput{ClusterName: aws.String(clusterName), NodegroupName: aws.String(name)})
+		if err != nil {
+			return nil, err
+		}
+		if aws.StringValue(nodegroup.Nodegroup.Version) != target {
+			plan.Nodegroups = append(plan.Nodegroups, name)
+		}
+	}
+
+	var addons []string
+	err = svc.ListAddonsPages(&eks.ListAddonsInput{ClusterName: aws.String(clusterName)}, func(page *eks.ListAddonsOutput, lastPage bool) bool {
+		addons = append(addons, aws.StringValueSlice(page.Addons)...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range addons {
+		addon, err := svc.DescribeAddon(&eks.DescribeAddonInput{ClusterName: aws.String(clusterName), AddonName: aws.String(name)})
+		if err != nil {
+			return nil, err
+		}
+		version, err := k8sversions.AddonVersion(svc, name, target)
+		if err != nil {
+			return nil, err
+		}
+		// EKS can't downgrade an add-on : an add-on installed in a newer version (latest) keeps it
+		current := aws.StringValue(addon.Addon.AddonVersion)
+		if !k8sversions.NewerAddonVersion(version, current) {
+			version = current
+		}
+		plan.Versions[name] = version
+		if name == ebsAddon {
+			plan.AddonVersion = version
+		}
+		if current != version {
+			plan.Addons = append(plan.Addons, addonUpgrade{Name: name, Current: current, Target: version})
+		}
+	}
+	// The addons stack is not deployed yet
+	if plan.AddonVersion == "" {
+		if plan.AddonVersion, err = k8sversions.AddonVersion(svc, ebsAddon, target); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// waitUpdate waits for the end of an update of the cluster, a node group or an add-on
+func waitUpdate(svc eksiface.EKSAPI, input *eks.DescribeUpdateInput) error {
+	for {
+		output, err := svc.DescribeUpdate(input)
+		if err != nil {
+			return err
+		}
+		switch status := aws.StringValue(output.Update.Status); status {
+		case eks.UpdateStatusSuccessful:
+			return nil
+		case eks.UpdateStatusFailed, eks.UpdateStatusCancelled:
+			var messages []string
+			for _, updateError := range output.Update.Errors {
+				messages = append(messages, aws.StringValue(updateError.ErrorMessage))
+			}
+			return fmt.Errorf("update %s %s : %s", aws.StringValue(input.UpdateId), strings.ToLower(status), strings.Join(messages, ", "))
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// upgradeControlPlane upgrades the Kubernetes version of the control plane
+func upgradeControlPlane(svc eksiface.EKSAPI, plan *upgradePlan) error {
+	if plan.Current == plan.Target {
+		return nil
+	}
+	output, err := svc.UpdateClusterVersion(&eks.UpdateClusterVersionInput{
+		Name:    aws.String(plan.Cluster),
+		Version: aws.String(plan.Target),
+	})
+	if err != nil {
+		return err
+	}
+	return waitUpdate(svc, &eks.DescribeUpdateInput{Name: aws.String(plan.Cluster), UpdateId: output.Update.Id})
+}
+
+// upgradeNodegroup upgrades a node group to the AMI of the version of the control plane,
+// the nodes are drained and replaced by EKS
+func upgradeNodegroup(svc eksiface.EKSAPI, plan *upgradePlan, nodegroup string) error {
+	output, err := svc.UpdateNodegroupVersion(&eks.UpdateNodegroupVersionInput{
+		ClusterName:   aws.String(plan.Cluster),
+		NodegroupName: aws.String(nodegroup),
+		Version:       aws.String(plan.Target),
+	})
+	if err != nil {
+		return err
+	}
+	return waitUpdate(svc, &eks.DescribeUpdateInput{Name: aws.String(plan.Cluster), NodegroupName: aws.String(nodegroup), UpdateId: output.Update.Id})
+}
+
+// upgradeAddon upgrades an add-on, keeping the changes made to its configuration in the cluster
+func upgradeAddon(svc eksiface.EKSAPI, plan *upgradePlan, addon addonUpgrade) error {
+	output, err := svc.UpdateAddon(&eks.UpdateAddonInput{
+		ClusterName:      aws.String(plan.Cluster),
+		AddonName:        aws.String(addon.Name),
+		AddonVersion:     aws.String(addon.Target),
+		ResolveConflicts: aws.String(eks.ResolveConflictsPreserve),
+	})
+	if err != nil {
+		return err
+	}
+	return waitUpdate(svc, &eks.DescribeUpdateInput{Name: aws.String(plan.Cluster), AddonName: aws.String(addon.Name), UpdateId: output.Update.Id})
+}
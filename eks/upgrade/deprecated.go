@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"CDK/pkg/k8sversions"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// deprecatedAPI is a deprecated API requested to the API server since its start
+// (metric apiserver_requested_deprecated_apis)
+type deprecatedAPI struct {
+	Group          string
+	Version        string
+	Resource       string
+	RemovedRelease string
+}
+
+func (api deprecatedAPI) String() string {
+	groupVersion := api.Version
+	if api.Group != "" {
+		groupVersion = api.Group + "/" + api.Version
+	}
+	if api.RemovedRelease == "" {
+		return fmt.Sprintf("%s %s", groupVersion, api.Resource)
+	}
+	return fmt.Sprintf("%s %s (removed in %s)", groupVersion, api.Resource, api.RemovedRelease)
+}
+
+var metricLabel = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// parseDeprecatedAPIs reads the deprecated APIs in the metrics of the API server
+func parseDeprecatedAPIs(metrics string) []deprecatedAPI {
+	var apis []deprecatedAPI
+	seen := make(map[deprecatedAPI]bool)
+	for _, line := range strings.Split(metrics, "\n") {
+		if !strings.HasPrefix(line, "apiserver_requested_deprecated_apis{") {
+			continue
+		}
+		labels := make(map[string]string)
+		for _, match := range metricLabel.FindAllStringSubmatch(line[:strings.Index(line, "}")+1], -1) {
+			labels[match[1]] = match[2]
+		}
+		api := deprecatedAPI{
+			Group:          labels["group"],
+			Version:        labels["version"],
+			Resource:       labels["resource"],
+			RemovedRelease: labels["removed_release"],
+		}
+		if !seen[api] {
+			seen[api] = true
+			apis = append(apis, api)
+		}
+	}
+	return apis
+}
+
+// removedAPIs returns the deprecated APIs removed in the target version or before
+func removedAPIs(apis []deprecatedAPI, target string) []deprecatedAPI {
+	targetMinor, err := k8sversions.Minor(target)
+	if err != nil {
+		return nil
+	}
+	var removed []deprecatedAPI
+	for _, api := range apis {
+		if minor, err := k8sversions.Minor(api.RemovedRelease); err == nil && minor <= targetMinor {
+			removed = append(removed, api)
+		}
+	}
+	return removed
+}
+
+// clusterDeprecatedAPIs returns the deprecated APIs requested to the API server of the cluster
+func clusterDeprecatedAPIs(ctx context.Context, clientset kubernetes.Interface) ([]deprecatedAPI, error) {
+	metrics, err := clientset.Discovery().RESTClient().Get().AbsPath("/metrics").DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading the metrics of the API server : %w", err)
+	}
+	return parseDeprecatedAPIs(string(metrics)), nil
+}
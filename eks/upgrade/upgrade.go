@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"CDK/pkg/k8sversions"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+type ConfAuth struct {
+	Region     string
+	Account    string
+	SSOProfile string
+	Index      string
+	AWSsecret  string
+}
+
+// Configuration is the part of the EKS stack configuration used by the upgrade
+type Configuration struct {
+	ClusterName  string
+	K8sVersion   string
+	AddonVersion string
+}
+
+// Addon is an add-on of the Addons of config.json, its Version is updated when fixed
+type Addon struct {
+	Name    string
+	Version string
+}
+
+const configFile = "../config.json"
+
+func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
+
+	fconfig, err := os.ReadFile(configFile)
+	if err != nil {
+		fmt.Println("❌ Problem with the configuration file : config.json")
+		os.Exit(1)
+	}
+	if err := json.Unmarshal(fconfig, &configjs); err != nil {
+		fmt.Println("❌ Error unmarshaling JSON:", err)
+		os.Exit(1)
+	}
+
+	fconfig2, err := os.ReadFile("../../config_crd.json")
+	if err != nil {
+		fmt.Println("❌ Problem with the configuration file : config_crd.json")
+		os.Exit(1)
+	}
+	if err := json.Unmarshal(fconfig2, &configcrd); err != nil {
+		fmt.Println("❌ Error unmarshaling JSON:", err)
+		os.Exit(1)
+	}
+	return configcrd, configjs
+}
+
+// setConfigValue replaces the value of a string parameter of config.json, keeping the layout of the file
+func setConfigValue(config []byte, key string, value string) ([]byte, error) {
+	parameter := regexp.MustCompile(`("` + regexp.QuoteMeta(key) + `"\s*:\s*)"[^"]*"`)
+	if !parameter.Match(config) {
+		return nil, fmt.Errorf("parameter %s not found", key)
+	}
+	return parameter.ReplaceAllFunc(config, func(match []byte) []byte {
+		prefix := parameter.FindSubmatch(match)[1]
+		return append(append([]byte{}, prefix...), []byte(`"`+value+`"`)...)
+	}), nil
+}
+
+// setAddonVersions replaces the fixed Version of the Addons of config.json (not empty or latest)
+// with the newer versions of the add-ons by EKS name, keeping the layout of the file. It returns
+// the add-ons updated
+func setAddonVersions(config []byte, versions map[string]string) ([]byte, []string, error) {
+	dec := json.NewDecoder(bytes.NewReader(config))
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	var updated []string
+	var result []byte
+	last := 0
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		if key != "Addons" {
+			var value json.RawMessage
+			if err := dec.Decode(&value); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		// "Addons": [] or null
+		if delim, err := dec.Token(); err != nil || delim != json.Delim('[') {
+			break
+		}
+		for dec.More() {
+			start := int(dec.InputOffset())
+			var addon Addon
+			if err := dec.Decode(&addon); err != nil {
+				return nil, nil, err
+			}
+			end := int(dec.InputOffset())
+			version, ok := versions[k8sversions.AddonName(addon.Name)]
+			if addon.Version == "" || addon.Version == "latest" || !ok || !k8sversions.NewerAddonVersion(version, addon.Version) {
+				continue
+			}
+			loc, err := versionIndex(config[start:end])
+			if err != nil {
+				return nil, nil, fmt.Errorf("add-on %s : %v", addon.Name, err)
+			}
+			result = append(result, config[last:start+loc[0]]...)
+			result = append(result, []byte(`"`+version+`"`)...)
+			last = start + loc[1]
+			updated = append(updated, addon.Name+" "+version)
+		}
+		break
+	}
+	return append(result, config[last:]...), updated, nil
+}
+
+// versionIndex returns the position of the value of the Version of an add-on of Addons, not of
+// a Version of its ConfigurationValues
+func versionIndex(addon []byte) ([]int, error) {
+	offset := bytes.IndexByte(addon, '{')
+	dec := json.NewDecoder(bytes.NewReader(addon[offset:]))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if key == "Version" {
+			end := offset + int(dec.InputOffset())
+			return []int{end - len(value), end}, nil
+		}
+	}
+	return nil, fmt.Errorf("Version not found")
+}
+
+// kubeClientset returns the client of the kubeconfig, which must point to the endpoint of the
+// cluster (or to the local port of tunnel.sh, with the name of the endpoint)
+func kubeClientset(endpoint string, clusterName string) (*kubernetes.Clientset, error) {
+	kubeconfigPath := filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	host := strings.TrimPrefix(endpoint, "https://")
+	if strings.TrimPrefix(config.Host, "https://") != host && config.TLSClientConfig.ServerName != host {
+		return nil, fmt.Errorf("the kubeconfig doesn't point to %s : aws eks update-kubeconfig --name %s", clusterName, clusterName)
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+func printPlan(plan *upgradePlan) {
+	fmt.Printf("Upgrade of %s from %s to %s :\n", plan.Cluster, plan.Current, plan.Target)
+	if plan.Current != plan.Target {
+		fmt.Printf("  1. Control plane      %s -> %s\n", plan.Current, plan.Target)
+	} else {
+		fmt.Printf("  1. Control plane      already in %s\n", plan.Target)
+	}
+	if len(plan.Nodegroups) > 0 {
+		fmt.Printf("  2. Node groups        %s -> %s\n", strings.Join(plan.Nodegroups, ", "), plan.Target)
+	} else {
+		fmt.Printf("  2. Node groups        already in %s\n", plan.Target)
+	}
+	if len(plan.Addons) == 0 {
+		fmt.Println("  3. Add-ons            up to date")
+	}
+	for _, addon := range plan.Addons {
+		fmt.Printf("  3. Add-on %-20s %s -> %s\n", addon.Name, addon.Current, addon.Target)
+	}
+	fmt.Printf("  4. config.json        K8sVersion %s, AddonVersion %s, fixed Version of the Addons\n", plan.Target, plan.AddonVersion)
+	fmt.Printf("  5. EKS stack          kubectl layer %s, ALB controller %s (cdk deploy)\n", plan.Stack.Kubectl, plan.Stack.AlbController)
+}
+
+func main() {
+
+	var configcrd ConfAuth
+	var config1 Configuration
+	var AppConfig1, AppConfig = GetConfig(configcrd, config1)
+
+	// Parse command-line arguments
+	cmdArgs := os.Args[1:]
+	if len(cmdArgs) < 2 || len(cmdArgs) > 3 || (cmdArgs[0] != "plan" && cmdArgs[0] != "apply") ||
+		(len(cmdArgs) == 3 && (cmdArgs[0] != "apply" || cmdArgs[2] != "force")) {
+		fmt.Println("❌ Usage: go run . [plan|apply] <version> [force]")
+		os.Exit(1)
+	}
+	target := cmdArgs[1]
+	force := len(cmdArgs) == 3
+	clusterName := AppConfig.ClusterName + AppConfig1.Index
+
+	// Open AWS session
+	os.Setenv("AWS_SDK_LOAD_CONFIG", "true")
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String(AppConfig1.Region),
+	}))
+	svc := eks.New(sess)
+
+	plan, err := planUpgrade(svc, clusterName, target)
+	if err != nil {
+		fmt.Printf("❌ Error checking the upgrade of %s: %v\n", clusterName, err)
+		os.Exit(1)
+	}
+	if AppConfig.K8sVersion != plan.Current && AppConfig.K8sVersion != plan.Target {
+		fmt.Printf("❌ K8sVersion %s of config.json doesn't match the cluster version %s\n", AppConfig.K8sVersion, plan.Current)
+		os.Exit(1)
+	}
+
+	// Deprecated APIs first : the resources using an API removed in the target version must be
+	// migrated before the upgrade
+	clientset, err := kubeClientset(plan.Endpoint, clusterName)
+	if err != nil {
+		fmt.Printf("❌ Error connecting to the cluster: %v\n", err)
+		os.Exit(1)
+	}
+	apis, err := clusterDeprecatedAPIs(context.Background(), clientset)
+	if err != nil {
+		fmt.Printf("❌ Error checking the deprecated APIs: %v\n", err)
+		os.Exit(1)
+	}
+	removed := removedAPIs(apis, target)
+	if len(apis) == 0 {
+		fmt.Println("✅ No deprecated API requested since the start of the API server")
+	} else {
+		fmt.Println("Deprecated APIs requested since the start of the API server :")
+		for _, api := range apis {
+			fmt.Printf("  %s\n", api)
+		}
+	}
+	printPlan(plan)
+
+	if cmdArgs[0] == "plan" {
+		return
+	}
+	if len(removed) > 0 && !force {
+		fmt.Printf("❌ %d APIs are removed in %s : migrate the resources and their clients first, or run apply %s force\n", len(removed), target, target)
+		os.Exit(1)
+	}
+
+	if plan.Current != plan.Target {
+		fmt.Printf("Upgrading the control plane to %s...\n", target)
+		if err := upgradeControlPlane(svc, plan); err != nil {
+			fmt.Printf("❌ Error upgrading the control plane: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Control plane upgraded to %s\n", target)
+	}
+
+	for _, nodegroup := range plan.Nodegroups {
+		fmt.Printf("Upgrading the node group %s...\n", nodegroup)
+		if err := upgradeNodegroup(svc, plan, nodegroup); err != nil {
+			fmt.Printf("❌ Error upgrading the node group %s: %v\n", nodegroup, err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Node group %s upgraded to %s\n", nodegroup, target)
+	}
+
+	for _, addon := range plan.Addons {
+		fmt.Printf("Upgrading the add-on %s...\n", addon.Name)
+		if err := upgradeAddon(svc, plan, addon); err != nil {
+			fmt.Printf("❌ Error upgrading the add-on %s: %v\n", addon.Name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Add-on %s upgraded to %s\n", addon.Name, addon.Target)
+	}
+
+	// The stacks deploy the new versions
+	config, err := os.ReadFile(configFile)
+	if err == nil {
+		config, err = setConfigValue(config, "K8sVersion", target)
+	}
+	if err == nil {
+		config, err = setConfigValue(config, "AddonVersion", plan.AddonVersion)
+	}
+	var addons []string
+	if err == nil {
+		config, addons, err = setAddonVersions(config, plan.Versions)
+	}
+	if err == nil {
+		err = os.WriteFile(configFile, config, 0644)
+	}
+	if err != nil {
+		fmt.Printf("❌ Error updating config.json: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ config.json updated : K8sVersion %s, AddonVersion %s\n", target, plan.AddonVersion)
+	for _, addon := range addons {
+		fmt.Printf("✅ config.json updated : add-on %s\n", addon)
+	}
+	fmt.Println("   Deploy the EKS stack (kubectl layer, ALB controller) then the addons stack (cdk deploy)")
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+)
+
+// fakeEKS is a cluster in 1.27 with a node group, vpc-cni and the EBS CSI driver
+type fakeEKS struct {
+	eksiface.EKSAPI
+	updates []string
+	addons  map[string]string // versions of the add-ons installed instead of the default ones
+}
+
+func (f *fakeEKS) DescribeCluster(input *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
+	return &eks.DescribeClusterOutput{Cluster: &eks.Cluster{
+		Name:     input.Name,
+		Version:  aws.String("1.27"),
+		Endpoint: aws.String("https://0123456789ABCDEF.gr7.eu-west-1.eks.amazonaws.com"),
+	}}, nil
+}
+
+func (f *fakeEKS) ListNodegroupsPages(input *eks.ListNodegroupsInput, fn func(*eks.ListNodegroupsOutput, bool) bool) error {
+	fn(&eks.ListNodegroupsOutput{Nodegroups: aws.StringSlice([]string{"DefaultCapacity"})}, true)
+	return nil
+}
+
+func (f *fakeEKS) DescribeNodegroup(input *eks.DescribeNodegroupInput) (*eks.DescribeNodegroupOutput, error) {
+	return &eks.DescribeNodegroupOutput{Nodegroup: &eks.Nodegroup{NodegroupName: input.NodegroupName, Version: aws.String("1.27")}}, nil
+}
+
+func (f *fakeEKS) ListAddonsPages(input *eks.ListAddonsInput, fn func(*eks.ListAddonsOutput, bool) bool) error {
+	fn(&eks.ListAddonsOutput{Addons: aws.StringSlice([]string{"vpc-cni", ebsAddon})}, true)
+	return nil
+}
+
+func (f *fakeEKS) DescribeAddon(input *eks.DescribeAddonInput) (*eks.DescribeAddonOutput, error) {
+	versions := map[string]string{"vpc-cni": "v1.12.6-eksbuild.2", ebsAddon: "v1.25.0-eksbuild.1"}
+	for name, version := range f.addons {
+		versions[name] = version
+	}
+	return &eks.DescribeAddonOutput{Addon: &eks.Addon{AddonName: input.AddonName, AddonVersion: aws.String(versions[*input.AddonName])}}, nil
+}
+
+func addonVersionInfo(version string, defaultVersion bool) *eks.AddonVersionInfo {
+	return &eks.AddonVersionInfo{
+		AddonVersion:    aws.String(version),
+		Compatibilities: []*eks.Compatibility{{ClusterVersion: aws.String("1.28"), DefaultVersion: aws.Bool(defaultVersion)}},
+	}
+}
+
+// vpc-cni has a default version for 1.28, the EBS CSI driver only compatible versions
+func (f *fakeEKS) DescribeAddonVersionsPages(input *eks.DescribeAddonVersionsInput, fn func(*eks.DescribeAddonVersionsOutput, bool) bool) error {
+	var versions []*eks.AddonVersionInfo
+	switch *input.AddonName {
+	case "vpc-cni":
+		versions = []*eks.AddonVersionInfo{addonVersionInfo("v1.15.4-eksbuild.1", false), addonVersionInfo("v1.14.1-eksbuild.1", true)}
+	case ebsAddon:
+		versions = []*eks.AddonVersionInfo{addonVersionInfo("v1.24.1-eksbuild.1", false), addonVersionInfo("v1.25.0-eksbuild.1", false), addonVersionInfo("v1.9.0-eksbuild.1", false)}
+	}
+	fn(&eks.DescribeAddonVersionsOutput{Addons: []*eks.AddonInfo{{AddonName: input.AddonName, AddonVersions: versions}}}, true)
+	return nil
+}
+
+func (f *fakeEKS) UpdateClusterVersion(input *eks.UpdateClusterVersionInput) (*eks.UpdateClusterVersionOutput, error) {
+	f.updates = append(f.updates, "cluster "+*input.Version)
+	return &eks.UpdateClusterVersionOutput{Update: &eks.Update{Id: aws.String("cluster")}}, nil
+}
+
+func (f *fakeEKS) UpdateNodegroupVersion(input *eks.UpdateNodegroupVersionInput) (*eks.UpdateNodegroupVersionOutput, error) {
+	f.updates = append(f.updates, "nodegroup "+*input.NodegroupName+" "+*input.Version)
+	return &eks.UpdateNodegroupVersionOutput{Update: &eks.Update{Id: aws.String("nodegroup")}}, nil
+}
+
+// The update of the node group fails, the others succeed after one check in progress
+func (f *fakeEKS) DescribeUpdate(input *eks.DescribeUpdateInput) (*eks.DescribeUpdateOutput, error) {
+	f.updates = append(f.updates, "describe "+*input.UpdateId)
+	status := eks.UpdateStatusSuccessful
+	if *input.UpdateId == "nodegroup" {
+		status = eks.UpdateStatusFailed
+	} else if len(f.updates) < 3 {
+		status = eks.UpdateStatusInProgress
+	}
+	return &eks.DescribeUpdateOutput{Update: &eks.Update{
+		Id:     input.UpdateId,
+		Status: aws.String(status),
+		Errors: []*eks.ErrorDetail{{ErrorMessage: aws.String("PodEvictionFailure")}},
+	}}, nil
+}
+
+func TestValidateTarget(t *testing.T) {
+	for _, test := range []struct {
+		current, target string
+		valid           bool
+	}{
+		{"1.27", "1.28", true},
+		{"1.28", "1.29", true},
+		{"1.28", "1.28", true},
+		{"1.28", "1.27", false},
+		{"1.26", "1.28", false},
+		{"1.27", "1.29", false},
+		{"1.27", "1.28.1", false},
+	} {
+		err := validateTarget(test.current, test.target)
+		if (err == nil) != test.valid {
+			t.Errorf("validateTarget(%s, %s) : %v", test.current, test.target, err)
+		}
+	}
+}
+
+func TestPlanUpgrade(t *testing.T) {
+	plan, err := planUpgrade(&fakeEKS{}, "ClustWorkshop01", "1.28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Current != "1.27" || plan.Stack.Kubectl != "1.28" || plan.Stack.AlbController != "v2.5.1" {
+		t.Errorf("unexpected plan : %+v", plan)
+	}
+	if len(plan.Nodegroups) != 1 || plan.Nodegroups[0] != "DefaultCapacity" {
+		t.Errorf("unexpected node groups : %v", plan.Nodegroups)
+	}
+	// The default version of vpc-cni, the latest version of the EBS CSI driver (already installed)
+	if len(plan.Addons) != 1 || plan.Addons[0] != (addonUpgrade{Name: "vpc-cni", Current: "v1.12.6-eksbuild.2", Target: "v1.14.1-eksbuild.1"}) {
+		t.Errorf("unexpected add-ons : %+v", plan.Addons)
+	}
+	if plan.AddonVersion != "v1.25.0-eksbuild.1" {
+		t.Errorf("unexpected AddonVersion %s", plan.AddonVersion)
+	}
+	if plan.Versions["vpc-cni"] != "v1.14.1-eksbuild.1" || plan.Versions[ebsAddon] != "v1.25.0-eksbuild.1" {
+		t.Errorf("unexpected add-on versions : %v", plan.Versions)
+	}
+
+	// vpc-cni installed in its latest version is not downgraded to the default version
+	plan, err = planUpgrade(&fakeEKS{addons: map[string]string{"vpc-cni": "v1.15.4-eksbuild.1"}}, "ClustWorkshop01", "1.28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Addons) != 0 || plan.Versions["vpc-cni"] != "v1.15.4-eksbuild.1" {
+		t.Errorf("add-on downgraded : %+v, %v", plan.Addons, plan.Versions)
+	}
+
+	if _, err := planUpgrade(&fakeEKS{}, "ClustWorkshop01", "1.26"); err == nil {
+		t.Error("downgrade planned")
+	}
+}
+
+func TestUpgradeSteps(t *testing.T) {
+	pollInterval = 0
+	svc := &fakeEKS{}
+	plan := &upgradePlan{Cluster: "ClustWorkshop01", Current: "1.27", Target: "1.28", Nodegroups: []string{"DefaultCapacity"}}
+
+	if err := upgradeControlPlane(svc, plan); err != nil {
+		t.Fatal(err)
+	}
+	err := upgradeNodegroup(svc, plan, "DefaultCapacity")
+	if err == nil || !strings.Contains(err.Error(), "PodEvictionFailure") {
+		t.Errorf("unexpected error : %v", err)
+	}
+	expected := "cluster 1.28,describe cluster,describe cluster,nodegroup DefaultCapacity 1.28,describe nodegroup"
+	if updates := strings.Join(svc.updates, ","); updates != expected {
+		t.Errorf("unexpected calls : %s", updates)
+	}
+}
+
+func TestDeprecatedAPIs(t *testing.T) {
+	metrics := `# HELP apiserver_requested_deprecated_apis [STABLE] Gauge of deprecated APIs that have been requested
+# TYPE apiserver_requested_deprecated_apis gauge
+apiserver_requested_deprecated_apis{group="flowcontrol.apiserver.k8s.io",removed_release="1.29",resource="flowschemas",subresource="",version="v1beta2"} 1
+apiserver_requested_deprecated_apis{group="flowcontrol.apiserver.k8s.io",removed_release="1.29",resource="flowschemas",subresource="status",version="v1beta2"} 1
+apiserver_requested_deprecated_apis{group="autoscaling",removed_release="1.26",resource="horizontalpodautoscalers",subresource="",version="v2beta2"} 1
+apiserver_requested_deprecated_apis{group="",removed_release="",resource="componentstatuses",subresource="",version="v1"} 1
+apiserver_request_total{code="200",verb="GET"} 10
+`
+	apis := parseDeprecatedAPIs(metrics)
+	if len(apis) != 3 {
+		t.Fatalf("unexpected APIs : %v", apis)
+	}
+	if api := apis[0].String(); api != "flowcontrol.apiserver.k8s.io/v1beta2 flowschemas (removed in 1.29)" {
+		t.Errorf("unexpected API %s", api)
+	}
+
+	removed := removedAPIs(apis, "1.28")
+	if len(removed) != 1 || removed[0].Resource != "horizontalpodautoscalers" {
+		t.Errorf("unexpected APIs removed in 1.28 : %v", removed)
+	}
+	if removed := removedAPIs(apis, "1.29"); len(removed) != 2 {
+		t.Errorf("unexpected APIs removed in 1.29 : %v", removed)
+	}
+}
+
+func TestSetConfigValue(t *testing.T) {
+	config := []byte("{\n        \"ClusterName\" : \"ClustWorkshop\",\n        \"K8sVersion\" : \"1.27\",\n        \"AddonVersion\": \"v1.24.0-eksbuild.1\"\n}\n")
+
+	config, err := setConfigValue(config, "K8sVersion", "1.28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err = setConfigValue(config, "AddonVersion", "v1.25.0-eksbuild.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "{\n        \"ClusterName\" : \"ClustWorkshop\",\n        \"K8sVersion\" : \"1.28\",\n        \"AddonVersion\": \"v1.25.0-eksbuild.1\"\n}\n"
+	if string(config) != expected {
+		t.Errorf("unexpected config.json :\n%s", config)
+	}
+	if _, err := setConfigValue(config, "IpFamily", "ipv6"); err == nil {
+		t.Error("missing parameter replaced")
+	}
+}
+
+func TestSetAddonVersions(t *testing.T) {
+	config := []byte(`{
+        "K8sVersion" : "1.27",
+        "Addons": [
+            {"Name": "ebs-csi", "ServiceAccountRole": {}},
+            {"Name": "vpc-cni", "Version" : "v1.12.6-eksbuild.2", "ConfigurationValues": {"env": {"ENABLE_PREFIX_DELEGATION": "true"}}},
+            {"Name": "coredns", "Version": "latest"},
+            {"Name": "kube-proxy", "Version": "v1.28.2-eksbuild.2"},
+            {
+                "ConfigurationValues": {"Version": "v1.0.0"},
+                "Version": "v1.0.0",
+                "Name": "aws-efs-csi-driver"
+            }
+        ],
+        "Version": "v1.0.0"
+}
+`)
+	versions := map[string]string{
+		ebsAddon:             "v1.25.0-eksbuild.1",
+		"vpc-cni":            "v1.14.1-eksbuild.1",
+		"coredns":            "v1.10.1-eksbuild.4",
+		"kube-proxy":         "v1.28.1-eksbuild.1",
+		"aws-efs-csi-driver": "v1.7.0-eksbuild.1",
+	}
+	config, updated, err := setAddonVersions(config, versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+        "K8sVersion" : "1.27",
+        "Addons": [
+            {"Name": "ebs-csi", "ServiceAccountRole": {}},
+            {"Name": "vpc-cni", "Version" : "v1.14.1-eksbuild.1", "ConfigurationValues": {"env": {"ENABLE_PREFIX_DELEGATION": "true"}}},
+            {"Name": "coredns", "Version": "latest"},
+            {"Name": "kube-proxy", "Version": "v1.28.2-eksbuild.2"},
+            {
+                "ConfigurationValues": {"Version": "v1.0.0"},
+                "Version": "v1.7.0-eksbuild.1",
+                "Name": "aws-efs-csi-driver"
+            }
+        ],
+        "Version": "v1.0.0"
+}
+`
+	if string(config) != expected {
+		t.Errorf("unexpected config.json :\n%s", config)
+	}
+	if strings.Join(updated, ", ") != "vpc-cni v1.14.1-eksbuild.1, aws-efs-csi-driver v1.7.0-eksbuild.1" {
+		t.Errorf("unexpected add-ons updated : %v", updated)
+	}
+
+	// Without Addons, config.json is not changed
+	for _, config := range []string{`{"K8sVersion": "1.27", "AddonVersion": "v1.24.0-eksbuild.1"}`, `{"Addons": null}`} {
+		if updated, _, err := setAddonVersions([]byte(config), versions); err != nil || string(updated) != config {
+			t.Errorf("config.json changed : %s, %v", updated, err)
+		}
+	}
+}
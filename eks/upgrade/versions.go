@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"CDK/pkg/k8sversions"
+)
+
+// validateTarget checks that the EKS stack supports the target version and that EKS can upgrade
+// the cluster to it : one minor version at a time, the current version only upgrades the node groups
+func validateTarget(current string, target string) error {
+	if _, ok := k8sversions.Versions[target]; !ok {
+		return fmt.Errorf("version %s is not supported by the EKS stack : %v", target, k8sversions.Supported())
+	}
+	currentMinor, err := k8sversions.Minor(current)
+	if err != nil {
+		return err
+	}
+	targetMinor, err := k8sversions.Minor(target)
+	if err != nil {
+		return err
+	}
+	switch {
+	case targetMinor < currentMinor:
+		return fmt.Errorf("the cluster is in version %s, it can't be downgraded to %s", current, target)
+	case targetMinor > currentMinor+1:
+		return fmt.Errorf("the cluster is in version %s, EKS upgrades one minor version at a time : upgrade to 1.%d first", current, currentMinor+1)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"CDK/pkg/k8sversions"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseks"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/jsii-runtime-go"
+	kubectlv28 "github.com/cdklabs/awscdk-kubectl-go/kubectlv28/v2"
+)
+
+// k8sVersion is a Kubernetes version supported by the stack with its kubectl layer and ALB controller
+type k8sVersion struct {
+	kubectlLayer  func(stack awscdk.Stack) awslambda.ILayerVersion
+	albController func() awseks.AlbControllerVersion
+}
+
+// kubectlLayers and albControllers are the CDK constructs of the versions of pkg/k8sversions
+var kubectlLayers = map[string]func(stack awscdk.Stack) awslambda.ILayerVersion{
+	"1.28": kubectl128Layer,
+}
+
+var albControllers = map[string]func() awseks.AlbControllerVersion{
+	"v2.5.1": awseks.AlbControllerVersion_V2_5_1,
+}
+
+func kubectl128Layer(stack awscdk.Stack) awslambda.ILayerVersion {
+	return kubectlv28.NewKubectlV28Layer(stack, jsii.String("kubectl128layer"))
+}
+
+// clusterVersion returns the kubectl layer and ALB controller of K8sVersion
+func clusterVersion(AppConfig Configuration) (k8sVersion, error) {
+	version, err := k8sversions.Lookup(AppConfig.K8sVersion)
+	if err != nil {
+		return k8sVersion{}, err
+	}
+	kubectlLayer, ok := kubectlLayers[version.Kubectl]
+	if !ok {
+		return k8sVersion{}, fmt.Errorf("no kubectl layer %s for K8sVersion %s", version.Kubectl, AppConfig.K8sVersion)
+	}
+	albController, ok := albControllers[version.AlbController]
+	if !ok {
+		return k8sVersion{}, fmt.Errorf("no ALB controller %s for K8sVersion %s", version.AlbController, AppConfig.K8sVersion)
+	}
+	return k8sVersion{kubectlLayer: kubectlLayer, albController: albController}, nil
+}
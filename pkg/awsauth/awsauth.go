@@ -0,0 +1,234 @@
+package awsauth
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/yaml"
+)
+
+// The aws-auth ConfigMap maps the IAM roles and users to Kubernetes users and groups
+const (
+	ConfigMapName = "aws-auth"
+	Namespace     = "kube-system"
+)
+
+var (
+	roleARN = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:role/.+$`)
+	userARN = regexp.MustCompile(`^arn:aws[a-z-]*:iam::\d{12}:user/.+$`)
+)
+
+// RoleMapping is an entry of mapRoles
+type RoleMapping struct {
+	RoleARN  string   `json:"rolearn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// UserMapping is an entry of mapUsers
+type UserMapping struct {
+	UserARN  string   `json:"userarn"`
+	Username string   `json:"username"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// AwsAuth is the content of the aws-auth ConfigMap
+type AwsAuth struct {
+	Roles []RoleMapping
+	Users []UserMapping
+}
+
+// Parse reads mapRoles and mapUsers of the ConfigMap
+func Parse(configMap *corev1.ConfigMap) (*AwsAuth, error) {
+	awsAuth := &AwsAuth{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["mapRoles"]), &awsAuth.Roles); err != nil {
+		return nil, fmt.Errorf("mapRoles : %w", err)
+	}
+	if err := yaml.Unmarshal([]byte(configMap.Data["mapUsers"]), &awsAuth.Users); err != nil {
+		return nil, fmt.Errorf("mapUsers : %w", err)
+	}
+	return awsAuth, nil
+}
+
+// Validate checks the ARNs and usernames of the entries, an ARN can only be mapped once
+func (a *AwsAuth) Validate() error {
+	seen := make(map[string]bool)
+	for _, role := range a.Roles {
+		if !roleARN.MatchString(role.RoleARN) {
+			return fmt.Errorf("mapRoles : invalid role ARN %q", role.RoleARN)
+		}
+		if role.Username == "" {
+			return fmt.Errorf("mapRoles : no username for %s", role.RoleARN)
+		}
+		if seen[role.RoleARN] {
+			return fmt.Errorf("mapRoles : %s is mapped twice", role.RoleARN)
+		}
+		seen[role.RoleARN] = true
+	}
+	for _, user := range a.Users {
+		if !userARN.MatchString(user.UserARN) {
+			return fmt.Errorf("mapUsers : invalid user ARN %q", user.UserARN)
+		}
+		if user.Username == "" {
+			return fmt.Errorf("mapUsers : no username for %s", user.UserARN)
+		}
+		if seen[user.UserARN] {
+			return fmt.Errorf("mapUsers : %s is mapped twice", user.UserARN)
+		}
+		seen[user.UserARN] = true
+	}
+	return nil
+}
+
+// AddRole adds the mapping of a role, or replaces the mappings of the same role (duplicates
+// of earlier runs included). It reports whether the entries changed
+func (a *AwsAuth) AddRole(mapping RoleMapping) bool {
+	index, count := -1, 0
+	for i, role := range a.Roles {
+		if role.RoleARN == mapping.RoleARN {
+			if index < 0 {
+				index = i
+			}
+			count++
+		}
+	}
+	if count == 1 && a.Roles[index].Username == mapping.Username && equalGroups(a.Roles[index].Groups, mapping.Groups) {
+		return false
+	}
+	if index < 0 {
+		a.Roles = append(a.Roles, mapping)
+		return true
+	}
+	a.RemoveRole(mapping.RoleARN)
+	a.Roles = append(a.Roles[:index], append([]RoleMapping{mapping}, a.Roles[index:]...)...)
+	return true
+}
+
+// RemoveRole removes the mappings of a role, it reports whether the entries changed
+func (a *AwsAuth) RemoveRole(arn string) bool {
+	roles := a.Roles[:0]
+	for _, role := range a.Roles {
+		if role.RoleARN != arn {
+			roles = append(roles, role)
+		}
+	}
+	changed := len(roles) != len(a.Roles)
+	a.Roles = roles
+	return changed
+}
+
+// AddUser adds the mapping of a user, or replaces the mappings of the same user.
+// It reports whether the entries changed
+func (a *AwsAuth) AddUser(mapping UserMapping) bool {
+	index, count := -1, 0
+	for i, user := range a.Users {
+		if user.UserARN == mapping.UserARN {
+			if index < 0 {
+				index = i
+			}
+			count++
+		}
+	}
+	if count == 1 && a.Users[index].Username == mapping.Username && equalGroups(a.Users[index].Groups, mapping.Groups) {
+		return false
+	}
+	if index < 0 {
+		a.Users = append(a.Users, mapping)
+		return true
+	}
+	a.RemoveUser(mapping.UserARN)
+	a.Users = append(a.Users[:index], append([]UserMapping{mapping}, a.Users[index:]...)...)
+	return true
+}
+
+// RemoveUser removes the mappings of a user, it reports whether the entries changed
+func (a *AwsAuth) RemoveUser(arn string) bool {
+	users := a.Users[:0]
+	for _, user := range a.Users {
+		if user.UserARN != arn {
+			users = append(users, user)
+		}
+	}
+	changed := len(users) != len(a.Users)
+	a.Users = users
+	return changed
+}
+
+// apply writes the entries in mapRoles and mapUsers of the ConfigMap
+func (a *AwsAuth) apply(configMap *corev1.ConfigMap) error {
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	if len(a.Roles) > 0 {
+		mapRoles, err := yaml.Marshal(a.Roles)
+		if err != nil {
+			return err
+		}
+		configMap.Data["mapRoles"] = string(mapRoles)
+	} else {
+		delete(configMap.Data, "mapRoles")
+	}
+	if len(a.Users) > 0 {
+		mapUsers, err := yaml.Marshal(a.Users)
+		if err != nil {
+			return err
+		}
+		configMap.Data["mapUsers"] = string(mapUsers)
+	} else {
+		delete(configMap.Data, "mapUsers")
+	}
+	return nil
+}
+
+// Update reads the aws-auth ConfigMap, applies change and updates the ConfigMap when change
+// reports a change and the result is valid. It retries on conflicts with other writers
+func Update(ctx context.Context, clientset kubernetes.Interface, change func(*AwsAuth) bool) error {
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		configMap, err := clientset.CoreV1().ConfigMaps(Namespace).Get(ctx, ConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		awsAuth, err := Parse(configMap)
+		if err != nil {
+			return err
+		}
+		if !change(awsAuth) {
+			return nil
+		}
+		if err := awsAuth.Validate(); err != nil {
+			return err
+		}
+		if err := awsAuth.apply(configMap); err != nil {
+			return err
+		}
+		_, err = clientset.CoreV1().ConfigMaps(Namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// AddRole maps a role in the aws-auth ConfigMap of the cluster
+func AddRole(ctx context.Context, clientset kubernetes.Interface, mapping RoleMapping) error {
+	return Update(ctx, clientset, func(a *AwsAuth) bool { return a.AddRole(mapping) })
+}
+
+// RemoveRole removes the mapping of a role from the aws-auth ConfigMap of the cluster
+func RemoveRole(ctx context.Context, clientset kubernetes.Interface, arn string) error {
+	return Update(ctx, clientset, func(a *AwsAuth) bool { return a.RemoveRole(arn) })
+}
+
+func equalGroups(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
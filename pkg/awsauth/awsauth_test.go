@@ -0,0 +1,189 @@
+package awsauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	nodeRole  = "arn:aws:iam::123456789012:role/ClustWorkshop01NodeRole"
+	buildRole = "arn:aws:iam::123456789012:role/BuildAdminRole01"
+)
+
+func testClientset(data map[string]string) *fake.Clientset {
+	return fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: ConfigMapName, Namespace: Namespace},
+		Data:       data,
+	})
+}
+
+func readAwsAuth(t *testing.T, clientset *fake.Clientset) *AwsAuth {
+	configMap, err := clientset.CoreV1().ConfigMaps(Namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	awsAuth, err := Parse(configMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return awsAuth
+}
+
+func TestParse(t *testing.T) {
+	configMap := &corev1.ConfigMap{Data: map[string]string{
+		"mapRoles": `[{"rolearn":"` + nodeRole + `","username":"system:node:{{EC2PrivateDNSName}}","groups":["system:bootstrappers","system:nodes"]}]`,
+		"mapUsers": "- userarn: arn:aws:iam::123456789012:user/ops\n  username: ops\n  groups:\n    - system:masters\n",
+	}}
+	awsAuth, err := Parse(configMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(awsAuth.Roles) != 1 || awsAuth.Roles[0].RoleARN != nodeRole || len(awsAuth.Roles[0].Groups) != 2 {
+		t.Errorf("unexpected roles : %+v", awsAuth.Roles)
+	}
+	if len(awsAuth.Users) != 1 || awsAuth.Users[0].Username != "ops" {
+		t.Errorf("unexpected users : %+v", awsAuth.Users)
+	}
+
+	if _, err := Parse(&corev1.ConfigMap{Data: map[string]string{"mapRoles": "rolearn: ["}}); err == nil {
+		t.Error("invalid mapRoles parsed")
+	}
+}
+
+func TestAddRole(t *testing.T) {
+	clientset := testClientset(map[string]string{
+		"mapRoles": `[{"rolearn":"` + nodeRole + `","username":"system:node:{{EC2PrivateDNSName}}","groups":["system:bootstrappers","system:nodes"]}]`,
+	})
+	mapping := RoleMapping{RoleARN: buildRole, Username: "codebuild", Groups: []string{"system:masters"}}
+
+	// The second run leaves the ConfigMap unchanged
+	for i := 0; i < 2; i++ {
+		if err := AddRole(context.TODO(), clientset, mapping); err != nil {
+			t.Fatal(err)
+		}
+	}
+	awsAuth := readAwsAuth(t, clientset)
+	if len(awsAuth.Roles) != 2 || awsAuth.Roles[0].RoleARN != nodeRole {
+		t.Fatalf("unexpected roles : %+v", awsAuth.Roles)
+	}
+	if role := awsAuth.Roles[1]; role.RoleARN != buildRole || role.Username != "codebuild" || role.Groups[0] != "system:masters" {
+		t.Errorf("unexpected mapping : %+v", role)
+	}
+
+	// A new username replaces the mapping
+	mapping.Username = "build"
+	if err := AddRole(context.TODO(), clientset, mapping); err != nil {
+		t.Fatal(err)
+	}
+	if awsAuth := readAwsAuth(t, clientset); len(awsAuth.Roles) != 2 || awsAuth.Roles[1].Username != "build" {
+		t.Errorf("unexpected roles : %+v", awsAuth.Roles)
+	}
+}
+
+func TestAddRoleRemovesDuplicates(t *testing.T) {
+	clientset := testClientset(map[string]string{
+		"mapRoles": `- rolearn: ` + nodeRole + `
+  username: system:node:{{EC2PrivateDNSName}}
+  groups:
+    - system:bootstrappers
+    - system:nodes
+- rolearn: ` + buildRole + `
+  username: admin
+  groups:
+    - system:masters
+- rolearn: ` + buildRole + `
+  username: admin
+  groups:
+    - system:masters
+`,
+	})
+
+	err := AddRole(context.TODO(), clientset, RoleMapping{RoleARN: buildRole, Username: "admin", Groups: []string{"system:masters"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	awsAuth := readAwsAuth(t, clientset)
+	if len(awsAuth.Roles) != 2 || awsAuth.Roles[1].RoleARN != buildRole {
+		t.Errorf("unexpected roles : %+v", awsAuth.Roles)
+	}
+	if err := awsAuth.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoveRole(t *testing.T) {
+	clientset := testClientset(map[string]string{
+		"mapRoles": `- rolearn: ` + nodeRole + `
+  username: system:node:{{EC2PrivateDNSName}}
+  groups:
+    - system:bootstrappers
+    - system:nodes
+- rolearn: ` + buildRole + `
+  username: admin
+  groups:
+    - system:masters
+`,
+	})
+
+	// Removing a role already removed is a no-op
+	for i := 0; i < 2; i++ {
+		if err := RemoveRole(context.TODO(), clientset, buildRole); err != nil {
+			t.Fatal(err)
+		}
+	}
+	awsAuth := readAwsAuth(t, clientset)
+	if len(awsAuth.Roles) != 1 || awsAuth.Roles[0].RoleARN != nodeRole {
+		t.Errorf("unexpected roles : %+v", awsAuth.Roles)
+	}
+}
+
+func TestUpdateValidates(t *testing.T) {
+	data := map[string]string{
+		"mapRoles": `[{"rolearn":"` + nodeRole + `","username":"system:node:{{EC2PrivateDNSName}}","groups":["system:bootstrappers","system:nodes"]}]`,
+	}
+	clientset := testClientset(data)
+
+	for _, mapping := range []RoleMapping{
+		{RoleARN: "BuildAdminRole01", Username: "admin"},
+		{RoleARN: buildRole},
+	} {
+		if err := AddRole(context.TODO(), clientset, mapping); err == nil {
+			t.Errorf("invalid mapping %+v added", mapping)
+		}
+	}
+	// The ConfigMap is unchanged
+	configMap, _ := clientset.CoreV1().ConfigMaps(Namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if configMap.Data["mapRoles"] != data["mapRoles"] {
+		t.Errorf("ConfigMap updated : %s", configMap.Data["mapRoles"])
+	}
+}
+
+func TestUsers(t *testing.T) {
+	clientset := testClientset(nil)
+	user := "arn:aws:iam::123456789012:user/ops"
+
+	err := Update(context.TODO(), clientset, func(a *AwsAuth) bool {
+		return a.AddUser(UserMapping{UserARN: user, Username: "ops", Groups: []string{"system:masters"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMap, _ := clientset.CoreV1().ConfigMaps(Namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if !strings.Contains(configMap.Data["mapUsers"], "userarn: "+user) {
+		t.Errorf("unexpected mapUsers : %s", configMap.Data["mapUsers"])
+	}
+
+	err = Update(context.TODO(), clientset, func(a *AwsAuth) bool { return a.RemoveUser(user) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMap, _ = clientset.CoreV1().ConfigMaps(Namespace).Get(context.TODO(), ConfigMapName, metav1.GetOptions{})
+	if _, ok := configMap.Data["mapUsers"]; ok {
+		t.Errorf("mapUsers not removed : %s", configMap.Data["mapUsers"])
+	}
+}
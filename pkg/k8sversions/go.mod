@@ -0,0 +1,7 @@
+module CDK/pkg/k8sversions
+
+go 1.21.1
+
+require github.com/aws/aws-sdk-go v1.46.3
+
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
@@ -0,0 +1,144 @@
+package k8sversions
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+)
+
+// Version is what the EKS stack deploys for a Kubernetes version
+type Version struct {
+	Kubectl       string // version of kubectl in the kubectl layer
+	AlbController string // version of the ALB controller
+}
+
+// Versions are the Kubernetes versions supported by the EKS stack : kubectl supports an API server
+// one minor version above or below its own, the ALB controller v2.5 supports Kubernetes 1.22 and later.
+// The table must hold the next minor version before an upgrade, the EKS stack needs the kubectl
+// layer and the ALB controller of a new version
+var Versions = map[string]Version{
+	"1.27": {Kubectl: "1.28", AlbController: "v2.5.1"},
+	"1.28": {Kubectl: "1.28", AlbController: "v2.5.1"},
+	"1.29": {Kubectl: "1.28", AlbController: "v2.5.1"},
+}
+
+// Supported returns the supported Kubernetes versions, sorted
+func Supported() []string {
+	var versions []string
+	for version := range Versions {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// Lookup returns what the EKS stack deploys for a supported Kubernetes version
+func Lookup(version string) (Version, error) {
+	v, ok := Versions[version]
+	if !ok {
+		return Version{}, fmt.Errorf("unsupported Kubernetes version %q : %v", version, Supported())
+	}
+	return v, nil
+}
+
+var k8sVersion = regexp.MustCompile(`^1\.(\d+)$`)
+
+// Minor returns the minor version of a Kubernetes version 1.<minor>
+func Minor(version string) (int, error) {
+	match := k8sVersion.FindStringSubmatch(version)
+	if match == nil {
+		return 0, fmt.Errorf("invalid Kubernetes version %q : 1.<minor>", version)
+	}
+	return strconv.Atoi(match[1])
+}
+
+var versionNumbers = regexp.MustCompile(`\d+`)
+
+// NewerAddonVersion reports whether the add-on version a (v1.25.0-eksbuild.1) is newer than b
+func NewerAddonVersion(a string, b string) bool {
+	numbersA := versionNumbers.FindAllString(a, -1)
+	numbersB := versionNumbers.FindAllString(b, -1)
+	for i := 0; i < len(numbersA) && i < len(numbersB); i++ {
+		x, _ := strconv.Atoi(numbersA[i])
+		y, _ := strconv.Atoi(numbersB[i])
+		if x != y {
+			return x > y
+		}
+	}
+	return len(numbersA) > len(numbersB)
+}
+
+// addonVersions returns the default version of EKS of an add-on for a Kubernetes version ("" if
+// EKS has none) and its latest compatible version
+func addonVersions(svc eksiface.EKSAPI, addonName string, k8sVersion string) (string, string, error) {
+	var defaultVersion, latest string
+	err := svc.DescribeAddonVersionsPages(&eks.DescribeAddonVersionsInput{
+		AddonName:         aws.String(addonName),
+		KubernetesVersion: aws.String(k8sVersion),
+	}, func(page *eks.DescribeAddonVersionsOutput, lastPage bool) bool {
+		for _, addon := range page.Addons {
+			for _, version := range addon.AddonVersions {
+				name := aws.StringValue(version.AddonVersion)
+				for _, compatibility := range version.Compatibilities {
+					if aws.StringValue(compatibility.ClusterVersion) == k8sVersion && aws.BoolValue(compatibility.DefaultVersion) {
+						defaultVersion = name
+					}
+				}
+				if latest == "" || NewerAddonVersion(name, latest) {
+					latest = name
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if latest == "" {
+		return "", "", fmt.Errorf("no version of the add-on %s for Kubernetes %s", addonName, k8sVersion)
+	}
+	return defaultVersion, latest, nil
+}
+
+// AddonVersion returns the version of an add-on for a Kubernetes version : the default version
+// of EKS for this Kubernetes version, or the latest compatible version
+func AddonVersion(svc eksiface.EKSAPI, addonName string, k8sVersion string) (string, error) {
+	defaultVersion, latest, err := addonVersions(svc, addonName, k8sVersion)
+	if err != nil {
+		return "", err
+	}
+	if defaultVersion != "" {
+		return defaultVersion, nil
+	}
+	return latest, nil
+}
+
+// LatestAddonVersion returns the latest version of an add-on compatible with a Kubernetes version
+func LatestAddonVersion(svc eksiface.EKSAPI, addonName string, k8sVersion string) (string, error) {
+	_, latest, err := addonVersions(svc, addonName, k8sVersion)
+	return latest, err
+}
+
+// AddonNames are the EKS names of the add-ons known by a short name in the Addons of config.json
+var AddonNames = map[string]string{
+	"vpc-cni":             "vpc-cni",
+	"coredns":             "coredns",
+	"kube-proxy":          "kube-proxy",
+	"ebs-csi":             "aws-ebs-csi-driver",
+	"efs-csi":             "aws-efs-csi-driver",
+	"pod-identity-agent":  "eks-pod-identity-agent",
+	"snapshot-controller": "snapshot-controller",
+}
+
+// AddonName returns the EKS name of an add-on given by its short or EKS name
+func AddonName(name string) string {
+	if addonName, ok := AddonNames[name]; ok {
+		return addonName
+	}
+	return name
+}
@@ -0,0 +1,112 @@
+package k8sversions
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+)
+
+// fakeEKS returns the versions of vpc-cni (default version for 1.28) and of the EBS CSI driver
+// (compatible versions only) on two pages
+type fakeEKS struct {
+	eksiface.EKSAPI
+}
+
+func addonVersionInfo(version string, defaultVersion bool) *eks.AddonVersionInfo {
+	return &eks.AddonVersionInfo{
+		AddonVersion:    aws.String(version),
+		Compatibilities: []*eks.Compatibility{{ClusterVersion: aws.String("1.28"), DefaultVersion: aws.Bool(defaultVersion)}},
+	}
+}
+
+func (f *fakeEKS) DescribeAddonVersionsPages(input *eks.DescribeAddonVersionsInput, fn func(*eks.DescribeAddonVersionsOutput, bool) bool) error {
+	pages := map[string][][]*eks.AddonVersionInfo{
+		"vpc-cni": {
+			{addonVersionInfo("v1.15.4-eksbuild.1", false)},
+			{addonVersionInfo("v1.14.1-eksbuild.1", true)},
+		},
+		"aws-ebs-csi-driver": {
+			{addonVersionInfo("v1.24.1-eksbuild.1", false), addonVersionInfo("v1.9.0-eksbuild.1", false)},
+			{addonVersionInfo("v1.25.0-eksbuild.1", false)},
+		},
+	}[*input.AddonName]
+	for i, versions := range pages {
+		if !fn(&eks.DescribeAddonVersionsOutput{Addons: []*eks.AddonInfo{{AddonName: input.AddonName, AddonVersions: versions}}}, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestLookup(t *testing.T) {
+	for _, version := range Supported() {
+		if v, err := Lookup(version); err != nil || v.Kubectl == "" || v.AlbController == "" {
+			t.Errorf("Lookup(%s) : %+v, %v", version, v, err)
+		}
+	}
+	if _, err := Lookup("1.25"); err == nil {
+		t.Error("Kubernetes 1.25 supported without a compatible kubectl layer")
+	}
+}
+
+func TestMinor(t *testing.T) {
+	if minor, err := Minor("1.28"); err != nil || minor != 28 {
+		t.Errorf("Minor(1.28) : %d, %v", minor, err)
+	}
+	for _, version := range []string{"1.28.3", "v1.28", "2.0"} {
+		if _, err := Minor(version); err == nil {
+			t.Errorf("invalid version %s accepted", version)
+		}
+	}
+}
+
+func TestNewerAddonVersion(t *testing.T) {
+	for _, test := range []struct {
+		a, b  string
+		newer bool
+	}{
+		{"v1.25.0-eksbuild.1", "v1.24.1-eksbuild.1", true},
+		{"v1.25.0-eksbuild.2", "v1.25.0-eksbuild.1", true},
+		{"v1.10.0-eksbuild.1", "v1.9.0-eksbuild.1", true},
+		{"v1.9.0-eksbuild.1", "v1.10.0-eksbuild.1", false},
+		{"v1.25.0-eksbuild.1", "v1.25.0-eksbuild.1", false},
+	} {
+		if newer := NewerAddonVersion(test.a, test.b); newer != test.newer {
+			t.Errorf("NewerAddonVersion(%s, %s) : %v", test.a, test.b, newer)
+		}
+	}
+}
+
+func TestAddonVersion(t *testing.T) {
+	for _, test := range []struct {
+		addon, version, latest string
+	}{
+		{"vpc-cni", "v1.14.1-eksbuild.1", "v1.15.4-eksbuild.1"},
+		{"aws-ebs-csi-driver", "v1.25.0-eksbuild.1", "v1.25.0-eksbuild.1"},
+	} {
+		if version, err := AddonVersion(&fakeEKS{}, test.addon, "1.28"); err != nil || version != test.version {
+			t.Errorf("AddonVersion(%s) : %s, %v", test.addon, version, err)
+		}
+		if latest, err := LatestAddonVersion(&fakeEKS{}, test.addon, "1.28"); err != nil || latest != test.latest {
+			t.Errorf("LatestAddonVersion(%s) : %s, %v", test.addon, latest, err)
+		}
+	}
+	if _, err := AddonVersion(&fakeEKS{}, "unknown", "1.28"); err == nil {
+		t.Error("version of an unknown add-on")
+	}
+}
+
+func TestAddonName(t *testing.T) {
+	for name, addonName := range map[string]string{
+		"ebs-csi":                         "aws-ebs-csi-driver",
+		"aws-ebs-csi-driver":              "aws-ebs-csi-driver",
+		"coredns":                         "coredns",
+		"amazon-cloudwatch-observability": "amazon-cloudwatch-observability",
+	} {
+		if got := AddonName(name); got != addonName {
+			t.Errorf("AddonName(%s) : %s instead of %s", name, got, addonName)
+		}
+	}
+}
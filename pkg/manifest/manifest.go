@@ -0,0 +1,47 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// Load returns the Kubernetes manifests at path :
+//   - a YAML file is returned as is
+//   - a kustomization directory is rendered in-process with kustomize : the overlay
+//     path/overlays/<overlay> when it exists, else path/base, else the directory itself
+func Load(path string, overlay string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return os.ReadFile(path)
+	}
+
+	dir := path
+	if base := filepath.Join(path, "base"); isDir(base) {
+		dir = base
+	}
+	if overlayDir := filepath.Join(path, "overlays", overlay); overlay != "" && isDir(overlayDir) {
+		dir = overlayDir
+	}
+
+	// Bases may reference the plain manifests of the parent directory
+	options := krusty.MakeDefaultOptions()
+	options.LoadRestrictions = types.LoadRestrictionsNone
+
+	resMap, err := krusty.MakeKustomizer(options).Run(filesys.MakeFsOnDisk(), dir)
+	if err != nil {
+		return nil, err
+	}
+	return resMap.AsYaml()
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const storageClass = `apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: managed-csi
+parameters:
+  type: gp2
+provisioner: ebs.csi.aws.com
+`
+
+const prodOverlay = `resources:
+- ../../base
+patches:
+- patch: |-
+    apiVersion: storage.k8s.io/v1
+    kind: StorageClass
+    metadata:
+      name: managed-csi
+    parameters:
+      type: gp3
+`
+
+// writeFiles writes the files of a test directory, by path relative to dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"sc.yaml":                             storageClass,
+		"flat/kustomization.yaml":             "resources:\n- ../sc.yaml\n",
+		"sc/base/kustomization.yaml":          "resources:\n- ../../sc.yaml\n",
+		"sc/overlays/prod/kustomization.yaml": prodOverlay,
+	})
+
+	for _, test := range []struct {
+		path, overlay, volumeType string
+	}{
+		{"sc.yaml", "prod", "gp2"},
+		{"flat", "", "gp2"},
+		{"sc", "", "gp2"},
+		{"sc", "prod", "gp3"},
+		{"sc", "staging", "gp2"},
+	} {
+		content, err := Load(filepath.Join(dir, test.path), test.overlay)
+		if err != nil {
+			t.Fatalf("Load(%s, %q) : %v", test.path, test.overlay, err)
+		}
+		if !strings.Contains(string(content), "kind: StorageClass") || !strings.Contains(string(content), "type: "+test.volumeType) {
+			t.Errorf("Load(%s, %q) :\n%s", test.path, test.overlay, content)
+		}
+	}
+
+	// The file is returned as is
+	if content, _ := Load(filepath.Join(dir, "sc.yaml"), ""); string(content) != storageClass {
+		t.Errorf("file changed :\n%s", content)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"broken/kustomization.yaml": "resources:\n- missing.yaml\n",
+	})
+	for _, path := range []string{"missing.yaml", "broken"} {
+		if _, err := Load(filepath.Join(dir, path), ""); err == nil {
+			t.Errorf("Load(%s) without error", path)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 #!/bin/sh
 
 if [ "$1" = "deploy" ]; then
-    go run main.go deploy
+    go run . deploy
 elif [ "$1" = "destroy" ]; then
-    go run main.go destroy
+    go run . destroy
+elif [ "$1" = "upgrade" ]; then
+    go run . upgrade
+elif [ "$1" = "rollback" ]; then
+    go run . rollback $2
 else
-    echo "Usage: $0 [deploy|destroy]"
+    echo "Usage: $0 [deploy|destroy|upgrade|rollback [revision]]"
     exit 1
 fi
 
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+// Default chart : https://github.com/SonarSource/helm-chart-sonarqube
+const (
+	sonarChartName = "sonarqube"
+	sonarChartRepo = "https://SonarSource.github.io/helm-chart-sonarqube"
+)
+
+// helmMode reports whether SonarQube is deployed with the Helm chart (DeployMode : helm)
+// instead of the manifests in dist (DeployMode : manifest, default)
+func helmMode(AppConfig Configuration) (bool, error) {
+	switch AppConfig.DeployMode {
+	case "", "manifest":
+		return false, nil
+	case "helm":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown DeployMode %q : manifest or helm", AppConfig.DeployMode)
+}
+
+// helmServiceName returns the name of the SonarQube Service created by the chart
+// (fullname of the release)
+func helmServiceName(AppConfig Configuration) string {
+	if strings.Contains(AppConfig.HelmRelease, sonarChartName) {
+		return AppConfig.HelmRelease
+	}
+	return AppConfig.HelmRelease + "-" + sonarChartName
+}
+
+// helmValues generates the chart values from the configuration : image, external
+// PostgreSQL database, persistence, service, resources, env and node selector
+func helmValues(AppConfig Configuration, JDBCURL string, SonarTLS bool) (map[string]interface{}, error) {
+	i := strings.LastIndex(AppConfig.SonarTagImage, ":")
+	if i < 0 || strings.Contains(AppConfig.SonarTagImage[i:], "/") {
+		return nil, fmt.Errorf("SonarTagImage %q has no tag", AppConfig.SonarTagImage)
+	}
+	repository, tag := AppConfig.SonarTagImage[:i], AppConfig.SonarTagImage[i+1:]
+
+	service := map[string]interface{}{
+		"type":         "LoadBalancer",
+		"externalPort": AppConfig.SonarPort,
+		"annotations": map[string]interface{}{
+			"service.beta.kubernetes.io/aws-load-balancer-scheme": "internet-facing",
+			"service.beta.kubernetes.io/aws-load-balancer-type":   "nlb",
+		},
+	}
+	// Behind the ALB Ingress the SonarQube Service stays internal
+	if SonarTLS {
+		service = map[string]interface{}{
+			"type":         "ClusterIP",
+			"externalPort": AppConfig.SonarPort,
+		}
+	}
+
+	values := map[string]interface{}{
+		"image": map[string]interface{}{
+			"repository": repository,
+			"tag":        tag,
+		},
+		"postgresql": map[string]interface{}{
+			"enabled": false,
+		},
+		"jdbcOverwrite": map[string]interface{}{
+			"enable":                true,
+			"jdbcUrl":               JDBCURL,
+			"jdbcUsername":          AppConfig.Sonaruser,
+			"jdbcSecretName":        "sonarsecret",
+			"jdbcSecretPasswordKey": "SONAR_JDBC_PASSWORD",
+		},
+		"persistence": map[string]interface{}{
+			"enabled":      true,
+			"storageClass": AppConfig.StorageClass,
+		},
+		"service": service,
+	}
+
+	resources, err := sonarResources(AppConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(resources) > 0 {
+		values["resources"] = resources
+	}
+	if len(AppConfig.SonarEnv) > 0 {
+		values["env"] = sonarEnv(AppConfig)
+	}
+	if len(AppConfig.SonarNodeSelector) > 0 {
+		nodeSelector := make(map[string]interface{})
+		for key, value := range AppConfig.SonarNodeSelector {
+			nodeSelector[key] = value
+		}
+		values["nodeSelector"] = nodeSelector
+	}
+	return values, nil
+}
+
+// helmConfig initializes the Helm action configuration for the SonarQube namespace
+func helmConfig(kubeconfigPath string, namespace string) (*cli.EnvSettings, *action.Configuration, error) {
+	settings := cli.New()
+	settings.KubeConfig = kubeconfigPath
+	settings.SetNamespace(namespace)
+
+	actionConfig := new(action.Configuration)
+	if err := actionConfig.Init(settings.RESTClientGetter(), namespace, os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
+		return nil, nil, err
+	}
+	return settings, actionConfig, nil
+}
+
+// loadSonarChart downloads the SonarQube chart at HelmChartVersion (latest if empty)
+func loadSonarChart(AppConfig Configuration, settings *cli.EnvSettings, options *action.ChartPathOptions) (*chart.Chart, error) {
+	options.RepoURL = AppConfig.HelmChartRepo
+	if options.RepoURL == "" {
+		options.RepoURL = sonarChartRepo
+	}
+	options.Version = AppConfig.HelmChartVersion
+
+	chartPath, err := options.LocateChart(sonarChartName, settings)
+	if err != nil {
+		return nil, err
+	}
+	return loader.Load(chartPath)
+}
+
+// helmInstall installs the SonarQube release in the namespace NSSonar
+func helmInstall(kubeconfigPath string, AppConfig Configuration, values map[string]interface{}) (*release.Release, error) {
+	settings, actionConfig, err := helmConfig(kubeconfigPath, AppConfig.NSSonar)
+	if err != nil {
+		return nil, err
+	}
+
+	install := action.NewInstall(actionConfig)
+	install.ReleaseName = AppConfig.HelmRelease
+	install.Namespace = AppConfig.NSSonar
+
+	sonarChart, err := loadSonarChart(AppConfig, settings, &install.ChartPathOptions)
+	if err != nil {
+		return nil, err
+	}
+	return install.Run(sonarChart, values)
+}
+
+// helmUpgrade upgrades the SonarQube release with the chart version and values of the configuration
+func helmUpgrade(kubeconfigPath string, AppConfig Configuration, values map[string]interface{}) (*release.Release, error) {
+	settings, actionConfig, err := helmConfig(kubeconfigPath, AppConfig.NSSonar)
+	if err != nil {
+		return nil, err
+	}
+
+	upgrade := action.NewUpgrade(actionConfig)
+	upgrade.Namespace = AppConfig.NSSonar
+
+	sonarChart, err := loadSonarChart(AppConfig, settings, &upgrade.ChartPathOptions)
+	if err != nil {
+		return nil, err
+	}
+	return upgrade.Run(AppConfig.HelmRelease, sonarChart, values)
+}
+
+// helmRollback rolls the SonarQube release back to revision (previous revision if 0)
+func helmRollback(kubeconfigPath string, AppConfig Configuration, revision int) error {
+	_, actionConfig, err := helmConfig(kubeconfigPath, AppConfig.NSSonar)
+	if err != nil {
+		return err
+	}
+
+	rollback := action.NewRollback(actionConfig)
+	rollback.Version = revision
+	return rollback.Run(AppConfig.HelmRelease)
+}
+
+// helmUninstall removes the SonarQube release
+func helmUninstall(kubeconfigPath string, AppConfig Configuration) error {
+	_, actionConfig, err := helmConfig(kubeconfigPath, AppConfig.NSSonar)
+	if err != nil {
+		return err
+	}
+
+	_, err = action.NewUninstall(actionConfig).Run(AppConfig.HelmRelease)
+	return err
+}
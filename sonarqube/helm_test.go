@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestHelmMode(t *testing.T) {
+	for _, test := range []struct {
+		deployMode string
+		helm       bool
+		valid      bool
+	}{
+		{"", false, true},
+		{"manifest", false, true},
+		{"helm", true, true},
+		{"kustomize", false, false},
+	} {
+		helm, err := helmMode(Configuration{DeployMode: test.deployMode})
+		if (err == nil) != test.valid || helm != test.helm {
+			t.Errorf("helmMode(%q) : %v, %v", test.deployMode, helm, err)
+		}
+	}
+}
+
+func TestHelmServiceName(t *testing.T) {
+	for release, service := range map[string]string{
+		"sonarqube":      "sonarqube",
+		"sonarqube-prod": "sonarqube-prod",
+		"sonar":          "sonar-sonarqube",
+	} {
+		if name := helmServiceName(Configuration{HelmRelease: release}); name != service {
+			t.Errorf("helmServiceName(%q) : %s instead of %s", release, name, service)
+		}
+	}
+}
+
+func TestHelmValues(t *testing.T) {
+	AppConfig := Configuration{
+		SonarTagImage: "docker.io/sonarqube:10.3.0-community",
+		SonarPort:     "9000",
+		Sonaruser:     "sonarqube",
+		StorageClass:  "managed-csi",
+		SonarResources: Resources{
+			Requests: map[string]string{"cpu": "500m", "memory": "2Gi"},
+		},
+		SonarEnv:          map[string]string{"SONAR_WEB_JAVAOPTS": "-Xmx1g", "SONAR_CE_JAVAOPTS": "-Xmx1g"},
+		SonarNodeSelector: map[string]string{"workload": "sonarqube"},
+	}
+	values, err := helmValues(AppConfig, "jdbc:postgresql://postgres-service.databasepg1.svc.cluster.local:5432/sonarqube", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := values["image"].(map[string]interface{})
+	if image["repository"] != "docker.io/sonarqube" || image["tag"] != "10.3.0-community" {
+		t.Errorf("unexpected image : %v", image)
+	}
+	if values["postgresql"].(map[string]interface{})["enabled"] != false {
+		t.Error("embedded PostgreSQL enabled")
+	}
+	if jdbc := values["jdbcOverwrite"].(map[string]interface{}); jdbc["jdbcUsername"] != "sonarqube" || jdbc["jdbcSecretName"] != "sonarsecret" {
+		t.Errorf("unexpected database : %v", jdbc)
+	}
+	if service := values["service"].(map[string]interface{}); service["type"] != "LoadBalancer" {
+		t.Errorf("unexpected service : %v", service)
+	}
+	if requests := values["resources"].(map[string]interface{})["requests"].(map[string]interface{}); requests["memory"] != "2Gi" {
+		t.Errorf("unexpected requests : %v", requests)
+	}
+	// The env is sorted by name
+	if env := values["env"].([]interface{}); len(env) != 2 || env[0].(map[string]interface{})["name"] != "SONAR_CE_JAVAOPTS" {
+		t.Errorf("unexpected env : %v", env)
+	}
+	if nodeSelector := values["nodeSelector"].(map[string]interface{}); nodeSelector["workload"] != "sonarqube" {
+		t.Errorf("unexpected node selector : %v", nodeSelector)
+	}
+
+	// Behind the ALB Ingress the Service is internal
+	values, err = helmValues(AppConfig, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service := values["service"].(map[string]interface{}); service["type"] != "ClusterIP" || service["annotations"] != nil {
+		t.Errorf("unexpected service with TLS : %v", service)
+	}
+}
+
+func TestHelmValuesErrors(t *testing.T) {
+	for _, AppConfig := range []Configuration{
+		{SonarTagImage: "docker.io/sonarqube"},
+		{SonarTagImage: "localhost:5000/sonarqube"},
+		{SonarTagImage: "docker.io/sonarqube:community", SonarResources: Resources{Limits: map[string]string{"memory": "4 GB"}}},
+	} {
+		if _, err := helmValues(AppConfig, "", false); err == nil {
+			t.Errorf("invalid configuration accepted : %+v", AppConfig)
+		}
+	}
+}
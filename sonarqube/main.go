@@ -12,17 +12,23 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"time"
 
+	"CDK/pkg/manifest"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/jsii-runtime-go"
 	"github.com/briandowns/spinner"
 	"github.com/golang/glog"
 	_ "github.com/lib/pq"
-	yaml1 "gopkg.in/yaml.v2"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -30,6 +36,8 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
@@ -53,9 +61,34 @@ type Configuration struct {
 	DepSonar       string
 	PGsvc          string
 	SonarSVC       string
+	SonarSVCf      string
 	SonarPort      string
 	SonarTransport string
 	SonarTagImage  string
+	PGExposure     string
+	PGAllowedCidrs []string
+	// TLS termination : ALB Ingress with an ACM certificate and a Route 53 record
+	SonarHostname       string
+	SonarCertificateArn string
+	SonarHostedZoneId   string
+	// Deployment mode of SonarQube : manifest (dist) or helm (official chart)
+	DeployMode       string
+	HelmRelease      string
+	HelmChartRepo    string
+	HelmChartVersion string
+	// SonarQube Deployment settings patched in memory in DepSonar (0 or empty : keep the manifest value)
+	SonarReplicas     int64
+	SonarResources    Resources
+	SonarEnv          map[string]string
+	SonarNodeSelector map[string]string
+	// Kustomize overlay by Index for manifest paths which are kustomization directories
+	Overlays map[string]string
+}
+
+// Resources requests and limits of the SonarQube container (cpu, memory)
+type Resources struct {
+	Requests map[string]string
+	Limits   map[string]string
 }
 
 type ConfAuth struct {
@@ -75,84 +108,6 @@ type Token struct {
 	Type           string `json:"type"`
 }
 
-// Define the YAML structure for sonarqube manifest
-type DeploymentConfig struct {
-	APIVersion string `yaml:"apiVersion"`
-	Kind       string `yaml:"kind"`
-	Metadata   struct {
-		Name      string `yaml:"name"`
-		Namespace string `yaml:"namespace"`
-	} `yaml:"metadata"`
-	Spec struct {
-		Replicas int `yaml:"replicas"`
-		Selector struct {
-			MatchLabels struct {
-				App string `yaml:"app"`
-			} `yaml:"matchLabels"`
-		} `yaml:"selector"`
-		Template struct {
-			Metadata struct {
-				Name   string `yaml:"name"`
-				Labels struct {
-					App string `yaml:"app"`
-				} `yaml:"labels"`
-			} `yaml:"metadata"`
-			Spec struct {
-				SecurityContext struct {
-					RunAsUser  int `yaml:"runAsUser"`
-					RunAsGroup int `yaml:"runAsGroup"`
-				} `yaml:"securityContext"`
-				InitContainers []struct {
-					Name            string   `yaml:"name"`
-					Image           string   `yaml:"image"`
-					Command         []string `yaml:"command"`
-					ImagePullPolicy string   `yaml:"imagePullPolicy"`
-					SecurityContext struct {
-						Privileged bool `yaml:"privileged"`
-						RunAsUser  int  `yaml:"runAsUser"`
-					} `yaml:"securityContext"`
-					VolumeMounts []struct {
-						MountPath string `yaml:"mountPath"`
-						Name      string `yaml:"name"`
-					} `yaml:"volumeMounts"`
-				} `yaml:"initContainers"`
-				Containers []struct {
-					Name            string `yaml:"name"`
-					Image           string `yaml:"image"`
-					ImagePullPolicy string `yaml:"imagePullPolicy"`
-					Ports           []struct {
-						ContainerPort int `yaml:"containerPort"`
-					} `yaml:"ports"`
-					VolumeMounts []struct {
-						MountPath string `yaml:"mountPath"`
-						Name      string `yaml:"name"`
-					} `yaml:"volumeMounts"`
-					Env []struct {
-						Name      string `yaml:"name"`
-						ValueFrom struct {
-							SecretKeyRef struct {
-								Name string `yaml:"name"`
-								Key  string `yaml:"key"`
-							} `yaml:"secretKeyRef"`
-						} `yaml:"valueFrom"`
-					} `yaml:"env"`
-					SecurityContext struct {
-						Privileged bool `yaml:"privileged"`
-						RunAsUser  int  `yaml:"runAsUser"`
-						RunAsGroup int  `yaml:"runAsGroup"`
-					} `yaml:"securityContext"`
-				} `yaml:"containers"`
-				Volumes []struct {
-					Name                  string `yaml:"name"`
-					PersistentVolumeClaim struct {
-						ClaimName string `yaml:"claimName"`
-					} `yaml:"persistentVolumeClaim"`
-				} `yaml:"volumes"`
-			} `yaml:"spec"`
-		} `yaml:"template"`
-	} `yaml:"spec"`
-}
-
 func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
 
 	fconfig, err := os.ReadFile("config.json")
@@ -196,7 +151,7 @@ func openAWSSession(region string) *secretsmanager.SecretsManager {
 	return svc
 }
 
-func applyResourcesFromYAML(yamlContent []byte, clientset *kubernetes.Clientset, dd *dynamic.DynamicClient, ns string) error {
+func applyResourcesFromYAML(yamlContent []byte, clientset *kubernetes.Clientset, dd *dynamic.DynamicClient, ns string, patches ...func(*unstructured.Unstructured) error) error {
 	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(yamlContent), 100)
 
 	for {
@@ -216,6 +171,11 @@ func applyResourcesFromYAML(yamlContent []byte, clientset *kubernetes.Clientset,
 			return err
 		}
 		unstructuredObj := &unstructured.Unstructured{Object: unstructuredMap}
+		for _, patch := range patches {
+			if err := patch(unstructuredObj); err != nil {
+				return err
+			}
+		}
 		gr, err := restmapper.GetAPIGroupResources(clientset.Discovery())
 		if err != nil {
 			return err
@@ -284,15 +244,15 @@ func deleteNamespace(clientset *kubernetes.Clientset, namespace string) error {
 	return nil
 }
 
-func waitForDNSResolution(dnsName string) {
+func waitForDNSResolution(dnsName string, service string) {
 	spin1 := spinner.New(spinner.CharSets[37], 100*time.Millisecond, spinner.WithWriter(os.Stderr))
-	spin1.Prefix = " Waiting DNS resolution for Database service..."
+	spin1.Prefix = " Waiting DNS resolution for " + service + " service..."
 	spin1.Start()
 	for {
 		_, err := net.LookupIP(dnsName)
 		if err == nil {
 			spin1.Stop()
-			fmt.Printf("\n✅ DNS resolution for Database service is successful.\n")
+			fmt.Printf("\n✅ DNS resolution for %s service is successful.\n", service)
 			break
 		}
 
@@ -300,45 +260,286 @@ func waitForDNSResolution(dnsName string) {
 	}
 }
 
-// LoadConfigFromFile loads YAML configuration from a file
-func LoadConfigFromFile(filePath string) (*DeploymentConfig, error) {
-	fileContent, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, err
+// jdbcURL returns the JDBC URL of the PostgreSQL database inside the cluster
+func jdbcURL(AppConfig Configuration) string {
+	return "jdbc:postgresql://" + AppConfig.PGsvc + "." + AppConfig.NSDataBase + ".svc.cluster.local:5432/sonarqube?currentSchema=public"
+}
+
+// pgExposure returns how the Postgres Service is exposed : ClusterIP (default),
+// internal or internet-facing Network Load Balancer
+func pgExposure(AppConfig Configuration) (string, error) {
+	switch AppConfig.PGExposure {
+	case "", "ClusterIP":
+		return "ClusterIP", nil
+	case "internal":
+		return "internal", nil
+	case "internet-facing":
+		if len(AppConfig.PGAllowedCidrs) == 0 {
+			return "", fmt.Errorf("PGAllowedCidrs must be set when PGExposure is internet-facing")
+		}
+		return "internet-facing", nil
 	}
+	return "", fmt.Errorf("unknown PGExposure %q : ClusterIP, internal or internet-facing", AppConfig.PGExposure)
+}
 
-	config := &DeploymentConfig{}
-	err = yaml1.Unmarshal(fileContent, config)
-	if err != nil {
-		return nil, err
+// exposeService returns a patch setting the type of the Service serviceName and,
+// for a Network Load Balancer, its scheme and allowed source ranges
+func exposeService(serviceName, exposure string, allowedCidrs []string) func(*unstructured.Unstructured) error {
+	return func(obj *unstructured.Unstructured) error {
+		if obj.GetKind() != "Service" || obj.GetName() != serviceName {
+			return nil
+		}
+
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		delete(annotations, "service.beta.kubernetes.io/aws-load-balancer-scheme")
+		delete(annotations, "service.beta.kubernetes.io/aws-load-balancer-type")
+		unstructured.RemoveNestedField(obj.Object, "spec", "loadBalancerSourceRanges")
+
+		if exposure == "ClusterIP" {
+			obj.SetAnnotations(annotations)
+			return unstructured.SetNestedField(obj.Object, "ClusterIP", "spec", "type")
+		}
+
+		annotations["service.beta.kubernetes.io/aws-load-balancer-scheme"] = exposure
+		annotations["service.beta.kubernetes.io/aws-load-balancer-type"] = "nlb"
+		obj.SetAnnotations(annotations)
+
+		if exposure == "internet-facing" {
+			ranges := make([]interface{}, 0, len(allowedCidrs))
+			for _, cidr := range allowedCidrs {
+				ranges = append(ranges, cidr)
+			}
+			if err := unstructured.SetNestedSlice(obj.Object, ranges, "spec", "loadBalancerSourceRanges"); err != nil {
+				return err
+			}
+		}
+		return unstructured.SetNestedField(obj.Object, "LoadBalancer", "spec", "type")
 	}
+}
 
-	return config, nil
+// sonarTLS reports whether SonarQube is exposed through an ALB Ingress terminating TLS
+func sonarTLS(AppConfig Configuration) (bool, error) {
+	if AppConfig.SonarHostname == "" {
+		return false, nil
+	}
+	if AppConfig.SonarCertificateArn == "" {
+		return false, fmt.Errorf("SonarCertificateArn must be set when SonarHostname is set")
+	}
+	return true, nil
 }
 
-// UpdateContainerImage updates the image field of the specified container
-func UpdateContainerImage(config *DeploymentConfig, containerName, newImage string) {
-	for i := range config.Spec.Template.Spec.Containers {
-		if config.Spec.Template.Spec.Containers[i].Name == containerName {
-			config.Spec.Template.Spec.Containers[i].Image = newImage
-			break
+// sonarIngress builds the ALB Ingress for SonarQube : HTTPS listener with the ACM
+// certificate, HTTP redirected to HTTPS
+func sonarIngress(AppConfig Configuration, serviceName string) (*networkingv1.Ingress, error) {
+	port, err := strconv.Atoi(AppConfig.SonarPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SonarPort %q: %v", AppConfig.SonarPort, err)
+	}
+	ingressClass := "alb"
+	pathType := networkingv1.PathTypePrefix
+
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "sonarqube-ingress",
+			Namespace: AppConfig.NSSonar,
+			Annotations: map[string]string{
+				"alb.ingress.kubernetes.io/scheme":           "internet-facing",
+				"alb.ingress.kubernetes.io/target-type":      "ip",
+				"alb.ingress.kubernetes.io/listen-ports":     `[{"HTTP": 80}, {"HTTPS": 443}]`,
+				"alb.ingress.kubernetes.io/ssl-redirect":     "443",
+				"alb.ingress.kubernetes.io/certificate-arn":  AppConfig.SonarCertificateArn,
+				"alb.ingress.kubernetes.io/healthcheck-path": "/api/system/status",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: &ingressClass,
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: AppConfig.SonarHostname,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     "/",
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: serviceName,
+											Port: networkingv1.ServiceBackendPort{Number: int32(port)},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func waitForIngressReady(clientset *kubernetes.Clientset, ingressName, namespace string, pollingInterval time.Duration) (string, error) {
+	for {
+		ingress, err := clientset.NetworkingV1().Ingresses(namespace).Get(context.TODO(), ingressName, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+
+		if len(ingress.Status.LoadBalancer.Ingress) > 0 {
+			hostname := ingress.Status.LoadBalancer.Ingress[0].Hostname
+			if hostname != "" {
+				return hostname, nil
+			}
 		}
+
+		time.Sleep(pollingInterval)
 	}
 }
 
-// SaveConfigToFile saves the updated configuration to a file
-func SaveConfigToFile(config *DeploymentConfig, filePath string) error {
-	data, err := yaml1.Marshal(config)
+// changeSonarRecord creates (UPSERT) or deletes (DELETE) the CNAME record
+// pointing the SonarQube hostname to the ALB
+func changeSonarRecord(region, hostedZoneID, action, hostname, target string) error {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region),
+	})
 	if err != nil {
 		return err
 	}
+	svc := route53.New(sess)
+
+	_, err = svc.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(hostedZoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Comment: aws.String("SonarQube ALB Ingress"),
+			Changes: []*route53.Change{
+				{
+					Action: aws.String(action),
+					ResourceRecordSet: &route53.ResourceRecordSet{
+						Name: aws.String(hostname),
+						Type: aws.String(route53.RRTypeCname),
+						TTL:  aws.Int64(300),
+						ResourceRecords: []*route53.ResourceRecord{
+							{Value: aws.String(target)},
+						},
+					},
+				},
+			},
+		},
+	})
+	return err
+}
 
-	err = os.WriteFile(filePath, data, os.ModePerm)
+// sonarEnv returns the extra environment variables of the SonarQube container, sorted by name
+func sonarEnv(AppConfig Configuration) []interface{} {
+	names := make([]string, 0, len(AppConfig.SonarEnv))
+	for name := range AppConfig.SonarEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		env = append(env, map[string]interface{}{"name": name, "value": AppConfig.SonarEnv[name]})
+	}
+	return env
+}
+
+// sonarResources returns the requests and limits of the SonarQube container
+func sonarResources(AppConfig Configuration) (map[string]interface{}, error) {
+	resources := make(map[string]interface{})
+	for kind, list := range map[string]map[string]string{"requests": AppConfig.SonarResources.Requests, "limits": AppConfig.SonarResources.Limits} {
+		if len(list) == 0 {
+			continue
+		}
+		quantities := make(map[string]interface{})
+		for name, value := range list {
+			if _, err := resource.ParseQuantity(value); err != nil {
+				return nil, fmt.Errorf("invalid SonarResources %s %s %q: %v", kind, name, value, err)
+			}
+			quantities[name] = value
+		}
+		resources[kind] = quantities
+	}
+	return resources, nil
+}
+
+// sonarDeploymentPatch builds the strategic merge patch of the SonarQube Deployment from the
+// configuration : namespace, image, replicas, resources, env and node selector
+func sonarDeploymentPatch(AppConfig Configuration) (map[string]interface{}, error) {
+	container := map[string]interface{}{
+		"name":  "sonarqube",
+		"image": AppConfig.SonarTagImage,
+	}
+	resources, err := sonarResources(AppConfig)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if len(resources) > 0 {
+		container["resources"] = resources
+	}
+	if len(AppConfig.SonarEnv) > 0 {
+		container["env"] = sonarEnv(AppConfig)
 	}
 
-	return nil
+	podSpec := map[string]interface{}{
+		"containers": []interface{}{container},
+	}
+	if len(AppConfig.SonarNodeSelector) > 0 {
+		nodeSelector := make(map[string]interface{})
+		for key, value := range AppConfig.SonarNodeSelector {
+			nodeSelector[key] = value
+		}
+		podSpec["nodeSelector"] = nodeSelector
+	}
+
+	spec := map[string]interface{}{
+		"template": map[string]interface{}{
+			"spec": podSpec,
+		},
+	}
+	if AppConfig.SonarReplicas > 0 {
+		spec["replicas"] = AppConfig.SonarReplicas
+	}
+
+	return map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"namespace": AppConfig.NSSonar,
+		},
+		"spec": spec,
+	}, nil
+}
+
+// patchDeployment returns a patch applying a strategic merge patch to the Deployment
+// deploymentName : fields which are not patched are kept as is
+func patchDeployment(deploymentName string, patch map[string]interface{}) func(*unstructured.Unstructured) error {
+	return func(obj *unstructured.Unstructured) error {
+		if obj.GetKind() != "Deployment" || obj.GetName() != deploymentName {
+			return nil
+		}
+
+		original, err := utiljson.Marshal(obj.Object)
+		if err != nil {
+			return err
+		}
+		patchJSON, err := utiljson.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		patched, err := strategicpatch.StrategicMergePatch(original, patchJSON, appsv1.Deployment{})
+		if err != nil {
+			return err
+		}
+
+		patchedObj := make(map[string]interface{})
+		if err := utiljson.Unmarshal(patched, &patchedObj); err != nil {
+			return err
+		}
+		obj.Object = patchedObj
+		return nil
+	}
 }
 
 func main() {
@@ -347,7 +548,7 @@ func main() {
 	var config1 Configuration
 	var AppConfig1, AppConfig = GetConfig(configcrd, config1)
 
-	fmt.Printf("Test ID :", AppConfig1.Account)
+	fmt.Println("Test ID :", AppConfig1.Account)
 
 	pollingInterval := 5 * time.Second
 
@@ -376,8 +577,23 @@ func main() {
 		glog.Fatalf("❌ Failed to create a ClientSet: %v. Exiting.", err)
 	}
 
-	if len(cmdArgs) != 1 || (cmdArgs[0] != "deploy" && cmdArgs[0] != "destroy") {
-		fmt.Println("❌ Usage: go run main.go [deploy|destroy]")
+	if len(cmdArgs) == 0 || (cmdArgs[0] != "deploy" && cmdArgs[0] != "destroy" && cmdArgs[0] != "upgrade" && cmdArgs[0] != "rollback") ||
+		(cmdArgs[0] != "rollback" && len(cmdArgs) != 1) || len(cmdArgs) > 2 {
+		fmt.Println("❌ Usage: go run . [deploy|destroy|upgrade|rollback [revision]]")
+		os.Exit(1)
+	}
+
+	HelmMode, errConf := helmMode(AppConfig)
+	if errConf != nil {
+		fmt.Printf("❌ Error in configuration file config.json: %v\n", errConf)
+		os.Exit(1)
+	}
+	sonarService := AppConfig.SonarSVC
+	Overlay := AppConfig.Overlays[AppConfig1.Index]
+	if HelmMode {
+		sonarService = helmServiceName(AppConfig)
+	} else if cmdArgs[0] == "upgrade" || cmdArgs[0] == "rollback" {
+		fmt.Printf("❌ %s is only available with DeployMode helm\n", cmdArgs[0])
 		os.Exit(1)
 	}
 
@@ -385,6 +601,17 @@ func main() {
 
 	if cmdArgs[0] == "deploy" {
 
+		PGExposure, errConf := pgExposure(AppConfig)
+		if errConf != nil {
+			fmt.Printf("❌ Error in configuration file config.json: %v\n", errConf)
+			os.Exit(1)
+		}
+		SonarTLS, errConf := sonarTLS(AppConfig)
+		if errConf != nil {
+			fmt.Printf("❌ Error in configuration file config.json: %v\n", errConf)
+			os.Exit(1)
+		}
+
 		spin := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
 		spin.Prefix = "Deployment PostgreSQL Database : "
 		spin.Color("green", "bold")
@@ -429,7 +656,7 @@ func main() {
 			fmt.Printf("\n❌ Error creating PVC: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Println("\r✅ PVC Database : pgsql-data created successfully\n")
+		fmt.Print("\r✅ PVC Database : pgsql-data created successfully\n\n")
 
 		fmt.Printf("\r%s %s \n", spin.Prefix, "Creating secret database...")
 
@@ -439,7 +666,7 @@ func main() {
 			log.Fatal(err)
 		}
 
-		pvYAML, err := os.ReadFile(AppConfig.PGSecret)
+		pvYAML, err := manifest.Load(AppConfig.PGSecret, Overlay)
 		if err != nil {
 			spin.Stop()
 			fmt.Printf("\n ❌ Error reading Secret YAML file %s: %v\n", err, AppConfig.PGSecret)
@@ -451,7 +678,7 @@ func main() {
 			log.Fatalf("\n ❌ Error applying %s file %v\n", err, AppConfig.PGSecret)
 			return
 		}
-		fmt.Println("\r✅ Database secret created successfully\n")
+		fmt.Print("\r✅ Database secret created successfully\n\n")
 
 		fmt.Printf("\r%s %s \n", spin.Prefix, "Creating ConfigMap Init DB...")
 
@@ -473,11 +700,11 @@ func main() {
 			fmt.Printf("\n ❌ Error creating PGSQLInit configMaps: %v\n", err1)
 			os.Exit(1)
 		}
-		fmt.Println("\r✅ PGSQLInit configMaps created successfully\n")
+		fmt.Print("\r✅ PGSQLInit configMaps created successfully\n\n")
 
 		fmt.Printf("\r%s %s \n", spin.Prefix, "Creating ConfigMap DATA DB...")
 		// Create a ConfigMap DATA DB
-		pgcYAML, err := os.ReadFile(AppConfig.PGconf)
+		pgcYAML, err := manifest.Load(AppConfig.PGconf, Overlay)
 		if err != nil {
 			spin.Stop()
 			fmt.Printf("\n ❌ Error reading Secret YAML file %s: %v\n", err, AppConfig.PGconf)
@@ -489,34 +716,50 @@ func main() {
 			log.Fatalf("\n ❌ Error applying %s file %v\n", err, AppConfig.PGconf)
 			return
 		}
-		fmt.Println("\r✅ PGSQLData configMaps created successfully\n")
+		fmt.Print("\r✅ PGSQLData configMaps created successfully\n\n")
 
 		fmt.Printf("\r%s %s \n", spin.Prefix, "Deploy Postgresql deployment...")
 
 		// Deploy Postgresql
 
-		pgYAML, err := os.ReadFile(AppConfig.PGsql)
+		pgYAML, err := manifest.Load(AppConfig.PGsql, Overlay)
 		if err != nil {
 			spin.Stop()
 			fmt.Printf("\n ❌ Error reading PGSQL YAML file %s: %v\n", err, AppConfig.PGsql)
 			os.Exit(1)
 		}
-		err = applyResourcesFromYAML(pgYAML, clientset, dd, AppConfig.NSDataBase)
+		err = applyResourcesFromYAML(pgYAML, clientset, dd, AppConfig.NSDataBase, exposeService(AppConfig.PGsvc, PGExposure, AppConfig.PGAllowedCidrs))
 		if err != nil {
 			spin.Stop()
 			log.Fatalf("\n ❌ Error applying %s file %v\n", err, AppConfig.PGsql)
 			return
 		}
 
-		externalIP, ClusterIP, err := waitForServiceReady(clientset, AppConfig.PGsvc, AppConfig.NSDataBase, pollingInterval)
-		if err != nil {
-			spin.Stop()
-			fmt.Printf("\n ❌ Error waiting for service to become ready: %v\n", err)
-			os.Exit(1)
+		// Only a Load Balancer Service has an external hostname to wait for
+		var externalIP, ClusterIP string
+		if PGExposure == "ClusterIP" {
+			pgService, err := clientset.CoreV1().Services(AppConfig.NSDataBase).Get(context.TODO(), AppConfig.PGsvc, metav1.GetOptions{})
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n ❌ Error getting service %s: %v\n", AppConfig.PGsvc, err)
+				os.Exit(1)
+			}
+			ClusterIP = pgService.Spec.ClusterIP
+		} else {
+			externalIP, ClusterIP, err = waitForServiceReady(clientset, AppConfig.PGsvc, AppConfig.NSDataBase, pollingInterval)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n ❌ Error waiting for service to become ready: %v\n", err)
+				os.Exit(1)
+			}
 		}
-		JDBCURL := "jdbc:postgresql://" + AppConfig.PGsvc + "." + AppConfig.NSDataBase + ".svc.cluster.local:5432/sonarqube?currentSchema=public"
+		JDBCURL := jdbcURL(AppConfig)
 		spin.Stop()
-		fmt.Printf("\n✅ PostgreSQL Database Successful deployment External IP: %s\n", externalIP)
+		if externalIP != "" {
+			fmt.Printf("\n✅ PostgreSQL Database Successful deployment External IP: %s\n", externalIP)
+		} else {
+			fmt.Printf("\n✅ PostgreSQL Database Successful deployment Service : %s\n", PGExposure)
+		}
 		fmt.Printf("✅ JDBC URL : %s - IP : %s\n\n\n", JDBCURL, ClusterIP)
 
 		spin.Prefix = "Deployment SonarQube : "
@@ -539,24 +782,27 @@ func main() {
 
 		fmt.Printf("\r✅ Namespace %s created successfully\n", AppConfig.NSSonar)
 
-		fmt.Printf("\r%s %s \n", spin.Prefix, "creating PVCs...")
+		// The chart creates its own PVC
+		if !HelmMode {
+			fmt.Printf("\r%s %s \n", spin.Prefix, "creating PVCs...")
 
-		// Create PVCs for sonarqube
+			// Create PVCs for sonarqube
 
-		sonarYAML, err := os.ReadFile(AppConfig.PvcSonar)
-		if err != nil {
-			spin.Stop()
-			fmt.Printf("\n ❌ Error reading PVCSONAR YAML file %s: %v\n", err, AppConfig.PvcSonar)
-			os.Exit(1)
-		}
-		err = applyResourcesFromYAML(sonarYAML, clientset, dd, AppConfig.NSSonar)
-		if err != nil {
-			spin.Stop()
-			log.Fatalf("\n❌ Error applying %s file %v\n", err, AppConfig.PvcSonar)
-			os.Exit(1)
-		}
+			sonarYAML, err := manifest.Load(AppConfig.PvcSonar, Overlay)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n ❌ Error reading PVCSONAR YAML file %s: %v\n", err, AppConfig.PvcSonar)
+				os.Exit(1)
+			}
+			err = applyResourcesFromYAML(sonarYAML, clientset, dd, AppConfig.NSSonar)
+			if err != nil {
+				spin.Stop()
+				log.Fatalf("\n❌ Error applying %s file %v\n", err, AppConfig.PvcSonar)
+				os.Exit(1)
+			}
 
-		fmt.Printf("\r✅ SonarQube PVCs created successfully\n")
+			fmt.Printf("\r✅ SonarQube PVCs created successfully\n")
+		}
 
 		fmt.Printf("\r%s %s \n", spin.Prefix, "Creating sonar k8s secret...")
 		// Define the data for the Secret.
@@ -585,79 +831,135 @@ func main() {
 		}
 		fmt.Printf("\r✅ SonarQube k8s Secret for Database created successfully : %s\n", createdSecret.Name)
 
-		// Modify Sonarqube manifest : namespace and image tag : community, developer, enterprise
-		// show different image tag : https://hub.docker.com/_/sonarqube/tags
-
-		fmt.Printf("\r%s %s \n", spin.Prefix, "Updating sonarqube image tag...")
-		// Load YAML configuration from file
-		config, err := LoadConfigFromFile(AppConfig.DepSonar)
-		if err != nil {
-			log.Fatal(err)
-		}
+		if HelmMode {
+			fmt.Printf("\r%s %s \n", spin.Prefix, "Installing SonarQube Helm chart...")
+			values, err := helmValues(AppConfig, JDBCURL, SonarTLS)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error in configuration file config.json: %v\n", err)
+				os.Exit(1)
+			}
+			rel, err := helmInstall(kubeconfigPath, AppConfig, values)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error installing SonarQube Helm chart: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("\r✅ SonarQube release %s (chart %s) installed successfully\n", rel.Name, rel.Chart.Metadata.Version)
+		} else {
+			// Patch Sonarqube manifest in memory : namespace, image tag : community, developer, enterprise,
+			// replicas, resources, env and node selector. The file in dist is not modified
+			// show different image tag : https://hub.docker.com/_/sonarqube/tags
+			sonarPatch, err := sonarDeploymentPatch(AppConfig)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error in configuration file config.json: %v\n", err)
+				os.Exit(1)
+			}
 
-		// Update the container image
-		UpdateContainerImage(config, "sonarqube", AppConfig.SonarTagImage)
+			fmt.Printf("\r%s %s \n", spin.Prefix, "Deployment SonarQube POD...")
+			// Deploy SonarQube pods
 
-		// Save the updated configuration to a file
-		err = SaveConfigToFile(config, AppConfig.DepSonar)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Printf("\r✅ Manifest sonarqube.yml updated successfully")
-		spin.Stop()
+			sonardYAML, err := manifest.Load(AppConfig.DepSonar, Overlay)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error reading SONARQUBE YAML file %s: %v\n", err, AppConfig.DepSonar)
+				os.Exit(1)
+			}
+			err = applyResourcesFromYAML(sonardYAML, clientset, dd, AppConfig.NSSonar, patchDeployment("sonarqube", sonarPatch))
+			if err != nil {
+				spin.Stop()
+				log.Fatalf("\n❌ Error applying %s file %v\n", err, AppConfig.DepSonar)
+				os.Exit(1)
+			}
+			fmt.Printf("\r✅ SonarQube Pod Successful deployment\n")
 
-		fmt.Printf("\r%s %s \n", spin.Prefix, "Deployment SonarQube POD...")
-		// Deploy SonarQube pods
+			fmt.Printf("\r%s %s \n", spin.Prefix, "Deployment SonarQube Service...")
+			// Deploy SonarQube Service
 
-		sonardYAML, err := os.ReadFile(AppConfig.DepSonar)
-		if err != nil {
-			spin.Stop()
-			fmt.Printf("\n❌ Error reading SONARQUBE YAML file %s: %v\n", err, AppConfig.DepSonar)
-			os.Exit(1)
-		}
-		err = applyResourcesFromYAML(sonardYAML, clientset, dd, AppConfig.NSSonar)
-		if err != nil {
-			spin.Stop()
-			log.Fatalf("\n❌ Error applying %s file %v\n", err, AppConfig.DepSonar)
-			os.Exit(1)
+			sonarsvcYAML, err := manifest.Load(AppConfig.SonarSVCf, Overlay)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error reading SONARQUBE Service YAML file %s : %v\n", AppConfig.SonarSVCf, err)
+				os.Exit(1)
+			}
+			// Behind the ALB Ingress the SonarQube Service stays internal
+			var sonarsvcPatches []func(*unstructured.Unstructured) error
+			if SonarTLS {
+				sonarsvcPatches = append(sonarsvcPatches, exposeService(AppConfig.SonarSVC, "ClusterIP", nil))
+			}
+			err = applyResourcesFromYAML(sonarsvcYAML, clientset, dd, AppConfig.NSSonar, sonarsvcPatches...)
+			if err != nil {
+				spin.Stop()
+				log.Fatalf("\n❌ Error applying %s file %v\n", AppConfig.SonarSVCf, err)
+				os.Exit(1)
+			}
+			fmt.Printf("\r✅ SonarQube Service Successful deployment\n")
 		}
-		fmt.Printf("\r✅ SonarQube Pod Successful deployment\n")
 
-		fmt.Printf("\r%s %s \n", spin.Prefix, "Deployment SonarQube Service...")
-		// Deploy SonarQube Service
+		var SONARURL, SonarHostURL string
+		if SonarTLS {
+			fmt.Printf("\r%s %s \n", spin.Prefix, "Deployment SonarQube Ingress...")
+			ingress, err := sonarIngress(AppConfig, sonarService)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error in configuration file config.json: %v\n", err)
+				os.Exit(1)
+			}
+			_, err = clientset.NetworkingV1().Ingresses(AppConfig.NSSonar).Create(context.TODO(), ingress, metav1.CreateOptions{})
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error creating Ingress: %v\n", err)
+				os.Exit(1)
+			}
 
-		sonarsvcYAML, err := os.ReadFile("dist/sonarsvc.yaml")
-		if err != nil {
-			spin.Stop()
-			fmt.Printf("\n❌ Error reading SONARQUBE Service YAML file sonarsvc.yaml : %v\n", err)
-			os.Exit(1)
-		}
-		err = applyResourcesFromYAML(sonarsvcYAML, clientset, dd, AppConfig.NSSonar)
-		if err != nil {
-			spin.Stop()
-			log.Fatalf("\n❌ Error applying sonarsvc.yaml file %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("\r✅ SonarQube Service Successful deployment\n")
+			fmt.Printf("\r%s %s \n", spin.Prefix, "Waiting SonarQube Ingress up...")
+			albHostname, err := waitForIngressReady(clientset, ingress.Name, AppConfig.NSSonar, pollingInterval)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error waiting for ingress to become ready: %v\n", err)
+				os.Exit(1)
+			}
 
-		fmt.Printf("\r%s %s \n", spin.Prefix, "Waiting SonarQube Service up...")
+			if AppConfig.SonarHostedZoneId != "" {
+				err = changeSonarRecord(AppConfig1.Region, AppConfig.SonarHostedZoneId, route53.ChangeActionUpsert, AppConfig.SonarHostname, albHostname)
+				if err != nil {
+					spin.Stop()
+					fmt.Printf("\n❌ Error creating Route 53 record %s: %v\n", AppConfig.SonarHostname, err)
+					os.Exit(1)
+				}
+				fmt.Printf("\r✅ Route 53 record %s created successfully\n", AppConfig.SonarHostname)
+			}
 
-		externalIPS, _, err := waitForServiceReady(clientset, AppConfig.SonarSVC, AppConfig.NSSonar, pollingInterval)
-		if err != nil {
-			spin.Stop()
-			fmt.Printf("\n❌ Error waiting for service to become ready: %v\n", err)
-			os.Exit(1)
+			SONARURL = "https://" + AppConfig.SonarHostname
+			SonarHostURL = SONARURL
+		} else {
+			fmt.Printf("\r%s %s \n", spin.Prefix, "Waiting SonarQube Service up...")
+
+			externalIPS, _, err := waitForServiceReady(clientset, sonarService, AppConfig.NSSonar, pollingInterval)
+			if err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error waiting for service to become ready: %v\n", err)
+				os.Exit(1)
+			}
+			SONARURL = AppConfig.SonarTransport + externalIPS + ":9000"
+			SonarHostURL = AppConfig.SonarTransport + externalIPS + ":" + AppConfig.SonarPort
 		}
-		SONARURL := AppConfig.SonarTransport + externalIPS + ":9000"
 
 		fmt.Printf("\n\n✅ SonarQube deployment created successfully - External Connexion: %s\n", SONARURL)
 		fmt.Printf("\r✅ SonarQube deployment created successfully 😀\n\n")
 		spin.Stop()
 
-		waitForDNSResolution(externalIP)
+		if externalIP != "" {
+			waitForDNSResolution(externalIP, "Database")
+		}
+		if SonarTLS {
+			waitForDNSResolution(AppConfig.SonarHostname, "SonarQube")
+		}
 
 		/*--------------------------------------- Set SonarQube License -------------------------------*/
-		/* This part is Optionnal, it applies the license file for sonarqube (the license.lic file must be located in the directory where the deployment is launched) */
+		/* This part is Optionnal, it applies the license file for sonarqube (the license.lic file must be located in the directory where the deployment is launched)
+		   It needs a direct access to the database : PGExposure must be internal or internet-facing */
 
 		/*	spin.Start()
 
@@ -699,7 +1001,6 @@ func main() {
 
 		/*------------------------------Generated SonarQube Token and store in AWS secret ----------------------*/
 
-		SonarHostURL := AppConfig.SonarTransport + externalIPS + ":" + AppConfig.SonarPort
 		spin.Prefix = "Generated SonarQube Token :"
 		spin.Start()
 		baseURL := SonarHostURL + "/api/user_tokens/generate"
@@ -817,6 +1118,35 @@ func main() {
 		spin.Color("green", "bold")
 		spin.Start()
 
+		// Delete the Route 53 record of the SonarQube ALB Ingress
+		if AppConfig.SonarHostname != "" && AppConfig.SonarHostedZoneId != "" {
+			ingress, err := clientset.NetworkingV1().Ingresses(AppConfig.NSSonar).Get(context.TODO(), "sonarqube-ingress", metav1.GetOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				spin.Stop()
+				fmt.Printf("\n❌ Error getting Ingress: %v\n", err)
+				os.Exit(1)
+			}
+			if err == nil && len(ingress.Status.LoadBalancer.Ingress) > 0 {
+				err = changeSonarRecord(AppConfig1.Region, AppConfig.SonarHostedZoneId, route53.ChangeActionDelete, AppConfig.SonarHostname, ingress.Status.LoadBalancer.Ingress[0].Hostname)
+				if err != nil {
+					spin.Stop()
+					fmt.Printf("\n❌ Error deleting Route 53 record %s: %v\n", AppConfig.SonarHostname, err)
+					os.Exit(1)
+				}
+				fmt.Printf("\r✅ Route 53 record %s deleted successfully\n", AppConfig.SonarHostname)
+			}
+		}
+
+		if HelmMode {
+			fmt.Printf("\r%s %s \n", spin.Prefix, "Uninstall the SonarQube release...")
+			if err := helmUninstall(kubeconfigPath, AppConfig); err != nil {
+				spin.Stop()
+				fmt.Printf("\n❌ Error uninstalling release %s: %v\n", AppConfig.HelmRelease, err)
+				os.Exit(1)
+			}
+			fmt.Printf("\r✅ SonarQube release %s uninstalled successfully\n", AppConfig.HelmRelease)
+		}
+
 		fmt.Printf("\r%s %s \n", spin.Prefix, "Destroy the SonarQube Namespace...")
 		// Destroy the SonarQube Namespace
 		if err := deleteNamespace(clientset, AppConfig.NSSonar); err != nil {
@@ -824,7 +1154,7 @@ func main() {
 			fmt.Printf("\n❌ Error deleting namespace %s: %v\n", AppConfig.NSSonar, err)
 			os.Exit(1)
 		}
-		fmt.Println("\r✅ Deployment SonarQube deleted successfully\n")
+		fmt.Print("\r✅ Deployment SonarQube deleted successfully\n\n")
 		spin.Stop()
 
 		spin.Suffix = "Destroy Deployment Database : "
@@ -837,7 +1167,7 @@ func main() {
 			fmt.Printf("\n❌ Error deleting namespace %s: %v\n", AppConfig.NSDataBase, err)
 			os.Exit(1)
 		}
-		fmt.Println("\n ✅ Deployment Database deleted successfully\n")
+		fmt.Print("\n ✅ Deployment Database deleted successfully\n\n")
 		spin.Stop()
 
 		spin.Prefix = "Destroy AWS Secret ..."
@@ -861,6 +1191,64 @@ func main() {
 		fmt.Println("\n ✅ Secret deleted successfully:", secretName)
 		spin.Stop()
 
+	} else if cmdArgs[0] == "upgrade" {
+
+		/*--------------------------------- Upgrade Steps ------------------------------------*/
+
+		SonarTLS, errConf := sonarTLS(AppConfig)
+		if errConf != nil {
+			fmt.Printf("❌ Error in configuration file config.json: %v\n", errConf)
+			os.Exit(1)
+		}
+
+		spin := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
+		spin.Prefix = "Upgrade SonarQube : "
+		spin.Color("green", "bold")
+		spin.Start()
+
+		fmt.Printf("\r%s %s \n", spin.Prefix, "Upgrading SonarQube Helm chart...")
+		values, err := helmValues(AppConfig, jdbcURL(AppConfig), SonarTLS)
+		if err != nil {
+			spin.Stop()
+			fmt.Printf("\n❌ Error in configuration file config.json: %v\n", err)
+			os.Exit(1)
+		}
+		rel, err := helmUpgrade(kubeconfigPath, AppConfig, values)
+		if err != nil {
+			spin.Stop()
+			fmt.Printf("\n❌ Error upgrading release %s: %v\n", AppConfig.HelmRelease, err)
+			os.Exit(1)
+		}
+		spin.Stop()
+		fmt.Printf("\r✅ SonarQube release %s upgraded successfully : revision %d (chart %s)\n", rel.Name, rel.Version, rel.Chart.Metadata.Version)
+
+	} else if cmdArgs[0] == "rollback" {
+
+		/*--------------------------------- Rollback Steps ------------------------------------*/
+
+		// Without revision, roll back to the previous one
+		revision := 0
+		if len(cmdArgs) == 2 {
+			revision, err = strconv.Atoi(cmdArgs[1])
+			if err != nil {
+				fmt.Printf("❌ Invalid revision %s: %v\n", cmdArgs[1], err)
+				os.Exit(1)
+			}
+		}
+
+		spin := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
+		spin.Prefix = "Rollback SonarQube : "
+		spin.Color("green", "bold")
+		spin.Start()
+
+		fmt.Printf("\r%s %s \n", spin.Prefix, "Rolling back SonarQube release...")
+		if err := helmRollback(kubeconfigPath, AppConfig, revision); err != nil {
+			spin.Stop()
+			fmt.Printf("\n❌ Error rolling back release %s: %v\n", AppConfig.HelmRelease, err)
+			os.Exit(1)
+		}
+		spin.Stop()
+		fmt.Printf("\r✅ SonarQube release %s rolled back successfully\n", AppConfig.HelmRelease)
 	}
 
 }
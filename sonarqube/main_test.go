@@ -0,0 +1,242 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"CDK/pkg/manifest"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+)
+
+// testService is the Postgres Service of dist/pgservice.yaml, with the annotations of a previous exposure
+func testService() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name": "postgres-service",
+			"annotations": map[string]interface{}{
+				"service.beta.kubernetes.io/aws-load-balancer-scheme": "internet-facing",
+				"service.beta.kubernetes.io/aws-load-balancer-type":   "nlb",
+			},
+		},
+		"spec": map[string]interface{}{
+			"type":                     "LoadBalancer",
+			"loadBalancerSourceRanges": []interface{}{"0.0.0.0/0"},
+			"ports":                    []interface{}{map[string]interface{}{"port": int64(5432)}},
+		},
+	}}
+}
+
+func TestPgExposure(t *testing.T) {
+	for _, test := range []struct {
+		exposure     string
+		allowedCidrs []string
+		expected     string
+		valid        bool
+	}{
+		{"", nil, "ClusterIP", true},
+		{"ClusterIP", nil, "ClusterIP", true},
+		{"internal", nil, "internal", true},
+		{"internet-facing", []string{"203.0.113.0/24"}, "internet-facing", true},
+		{"internet-facing", nil, "", false},
+		{"NodePort", nil, "", false},
+	} {
+		exposure, err := pgExposure(Configuration{PGExposure: test.exposure, PGAllowedCidrs: test.allowedCidrs})
+		if (err == nil) != test.valid || exposure != test.expected {
+			t.Errorf("pgExposure(%q, %v) : %q, %v", test.exposure, test.allowedCidrs, exposure, err)
+		}
+	}
+}
+
+func TestExposeService(t *testing.T) {
+	for _, test := range []struct {
+		exposure     string
+		serviceType  string
+		scheme       string
+		sourceRanges []interface{}
+	}{
+		{"ClusterIP", "ClusterIP", "", nil},
+		{"internal", "LoadBalancer", "internal", nil},
+		{"internet-facing", "LoadBalancer", "internet-facing", []interface{}{"203.0.113.0/24"}},
+	} {
+		obj := testService()
+		if err := exposeService("postgres-service", test.exposure, []string{"203.0.113.0/24"})(obj); err != nil {
+			t.Fatal(err)
+		}
+		serviceType, _, _ := unstructured.NestedString(obj.Object, "spec", "type")
+		scheme := obj.GetAnnotations()["service.beta.kubernetes.io/aws-load-balancer-scheme"]
+		sourceRanges, _, _ := unstructured.NestedSlice(obj.Object, "spec", "loadBalancerSourceRanges")
+		if serviceType != test.serviceType || scheme != test.scheme || len(sourceRanges) != len(test.sourceRanges) {
+			t.Errorf("%s : type %q, scheme %q, source ranges %v", test.exposure, serviceType, scheme, sourceRanges)
+		}
+		if ports, _, _ := unstructured.NestedSlice(obj.Object, "spec", "ports"); len(ports) != 1 {
+			t.Errorf("%s : ports of the manifest lost : %v", test.exposure, ports)
+		}
+	}
+
+	// The other resources of the manifest are not changed
+	obj := testService()
+	obj.SetName("sonarqube-service")
+	if err := exposeService("postgres-service", "ClusterIP", nil)(obj); err != nil {
+		t.Fatal(err)
+	}
+	if serviceType, _, _ := unstructured.NestedString(obj.Object, "spec", "type"); serviceType != "LoadBalancer" {
+		t.Errorf("other Service patched : %s", serviceType)
+	}
+}
+
+func TestSonarTLS(t *testing.T) {
+	for _, test := range []struct {
+		hostname, certificateArn string
+		tls, valid               bool
+	}{
+		{"", "", false, true},
+		{"", "arn:aws:acm:eu-west-1:123456789012:certificate/0123", false, true},
+		{"sonar.example.com", "arn:aws:acm:eu-west-1:123456789012:certificate/0123", true, true},
+		{"sonar.example.com", "", false, false},
+	} {
+		tls, err := sonarTLS(Configuration{SonarHostname: test.hostname, SonarCertificateArn: test.certificateArn})
+		if (err == nil) != test.valid || tls != test.tls {
+			t.Errorf("sonarTLS(%q, %q) : %v, %v", test.hostname, test.certificateArn, tls, err)
+		}
+	}
+}
+
+func TestSonarIngress(t *testing.T) {
+	AppConfig := Configuration{
+		NSSonar:             "sonarqube1",
+		SonarPort:           "9000",
+		SonarHostname:       "sonar.example.com",
+		SonarCertificateArn: "arn:aws:acm:eu-west-1:123456789012:certificate/0123",
+	}
+	ingress, err := sonarIngress(AppConfig, "sonarqube-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ingress.Namespace != "sonarqube1" || *ingress.Spec.IngressClassName != "alb" {
+		t.Errorf("unexpected ingress : %s/%s", ingress.Namespace, *ingress.Spec.IngressClassName)
+	}
+	if ingress.Annotations["alb.ingress.kubernetes.io/certificate-arn"] != AppConfig.SonarCertificateArn || ingress.Annotations["alb.ingress.kubernetes.io/ssl-redirect"] != "443" {
+		t.Errorf("unexpected annotations : %v", ingress.Annotations)
+	}
+	rule := ingress.Spec.Rules[0]
+	backend := rule.HTTP.Paths[0].Backend.Service
+	if rule.Host != "sonar.example.com" || backend.Name != "sonarqube-service" || backend.Port.Number != 9000 {
+		t.Errorf("unexpected rule : %s -> %s:%d", rule.Host, backend.Name, backend.Port.Number)
+	}
+
+	AppConfig.SonarPort = "http"
+	if _, err := sonarIngress(AppConfig, "sonarqube-service"); err == nil {
+		t.Error("invalid SonarPort accepted")
+	}
+}
+
+// testDeployment returns the SonarQube Deployment of dist/sonarqube.yaml
+func testDeployment(t *testing.T) *unstructured.Unstructured {
+	content, err := os.ReadFile("dist/sonarqube.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, _, err := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme).Decode(content, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj.(*unstructured.Unstructured)
+}
+
+func TestSonarDeploymentPatch(t *testing.T) {
+	AppConfig := Configuration{
+		NSSonar:       "sonarqube1",
+		SonarTagImage: "docker.io/sonarqube:10.3.0-community",
+		SonarReplicas: 2,
+		SonarResources: Resources{
+			Limits: map[string]string{"memory": "6Gi"},
+		},
+		SonarEnv:          map[string]string{"SONAR_WEB_JAVAOPTS": "-Xmx1g"},
+		SonarNodeSelector: map[string]string{"workload": "sonarqube"},
+	}
+	patch, err := sonarDeploymentPatch(AppConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := testDeployment(t)
+	initContainers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "initContainers")
+	if err := patchDeployment("sonarqube", patch)(obj); err != nil {
+		t.Fatal(err)
+	}
+
+	if obj.GetNamespace() != "sonarqube1" {
+		t.Errorf("unexpected namespace %q", obj.GetNamespace())
+	}
+	if replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas"); replicas != 2 {
+		t.Errorf("unexpected replicas %d", replicas)
+	}
+	containers, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+	container := containers[0].(map[string]interface{})
+	if container["image"] != AppConfig.SonarTagImage {
+		t.Errorf("unexpected image %v", container["image"])
+	}
+	if memory, _, _ := unstructured.NestedString(container, "resources", "limits", "memory"); memory != "6Gi" {
+		t.Errorf("unexpected memory limit %q", memory)
+	}
+	// The env and the volumes of the manifest are merged with the configuration
+	env, _, _ := unstructured.NestedSlice(container, "env")
+	var javaOpts bool
+	for _, variable := range env {
+		if variable.(map[string]interface{})["name"] == "SONAR_WEB_JAVAOPTS" {
+			javaOpts = true
+		}
+	}
+	if !javaOpts || len(env) < 2 {
+		t.Errorf("unexpected env : %v", env)
+	}
+	if mounts, _, _ := unstructured.NestedSlice(container, "volumeMounts"); len(mounts) == 0 {
+		t.Error("volume mounts of the manifest lost")
+	}
+	if patched, _, _ := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "initContainers"); len(patched) != len(initContainers) {
+		t.Errorf("%d init containers instead of %d", len(patched), len(initContainers))
+	}
+	if nodeSelector, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "template", "spec", "nodeSelector"); nodeSelector["workload"] != "sonarqube" {
+		t.Errorf("unexpected node selector : %v", nodeSelector)
+	}
+}
+
+func TestSonarDeploymentPatchDefaults(t *testing.T) {
+	patch, err := sonarDeploymentPatch(Configuration{NSSonar: "sonarqube1", SonarTagImage: "docker.io/sonarqube:community"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := testDeployment(t)
+	if err := patchDeployment("sonarqube", patch)(obj); err != nil {
+		t.Fatal(err)
+	}
+	// 0 or empty : the values of the manifest are kept
+	if replicas, _, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas"); replicas != 1 {
+		t.Errorf("unexpected replicas %d", replicas)
+	}
+
+	if _, err := sonarDeploymentPatch(Configuration{SonarResources: Resources{Requests: map[string]string{"cpu": "half"}}}); err == nil {
+		t.Error("invalid resources accepted")
+	}
+}
+
+func TestDepSonarOverlays(t *testing.T) {
+	for overlay, memory := range map[string]string{"": "", "dev": "2Gi", "prod": "4Gi"} {
+		content, err := manifest.Load("dist/sonarqube", overlay)
+		if err != nil {
+			t.Fatalf("overlay %q : %v", overlay, err)
+		}
+		obj, _, err := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme).Decode(content, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		containers, _, _ := unstructured.NestedSlice(obj.(*unstructured.Unstructured).Object, "spec", "template", "spec", "containers")
+		request, _, _ := unstructured.NestedString(containers[0].(map[string]interface{}), "resources", "requests", "memory")
+		if obj.GetObjectKind().GroupVersionKind().Kind != "Deployment" || request != memory {
+			t.Errorf("overlay %q : memory request %q instead of %q", overlay, request, memory)
+		}
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awslogs"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awss3"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsssm"
+	"github.com/aws/aws-cdk-go/awscdk/v2/customresources"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 )
@@ -26,11 +30,63 @@ type ConfAuth struct {
 }
 
 type Configuration struct {
-	VpcName       string
-	Vpccidr       string
-	Za            float64
-	SgName        string
-	SgDescription string
+	VpcName        string
+	Vpccidr        string
+	Za             float64
+	SgName         string
+	SgDescription  string
+	Subnets        []SubnetGroup
+	NatGateways    *float64
+	NatInstance    string
+	Endpoints      []string
+	IPv6           bool
+	FlowLogs       FlowLogs
+	SecurityGroups []SecurityGroup
+	// Import of an existing VPC named VpcName+Index, looked up with the CDK context providers
+	ImportVpc           bool
+	ImportSecurityGroup bool // look up the security group SgName+Index instead of creating it
+}
+
+// SecurityGroup is a named security group of the VPC, exported for the next stacks
+type SecurityGroup struct {
+	Name             string
+	Description      string
+	AllowAllOutbound *bool // default true, false to use the Egress rules
+	Ingress          []SecurityGroupRule
+	Egress           []SecurityGroupRule
+}
+
+// SecurityGroupRule allows the traffic from (Ingress) or to (Egress) a peer
+type SecurityGroupRule struct {
+	Peer        string // IPv4 or IPv6 CIDR, prefix list ID (pl-), security group ID (sg-) or Name of a security group of the configuration
+	Protocol    string // tcp (default), udp, icmp or all
+	Ports       string // port (5432) or range (30000-32767), all ports if empty
+	Description string
+}
+
+// FlowLogs of the VPC, disabled without Destination
+type FlowLogs struct {
+	Destination   string   // cloudwatch or s3
+	TrafficType   string   // ALL (default), ACCEPT or REJECT
+	RetentionDays float64  // 0 : never expire
+	Format        []string // fields of the flow log records, default format if empty (version, account-id, ...)
+}
+
+// logRetentionDays are the retention periods accepted by CloudWatch Logs
+var logRetentionDays = []float64{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+// SubnetGroup is a group of subnets created in each Availability Zone
+type SubnetGroup struct {
+	Name     string
+	Type     string // public, private-with-egress or isolated
+	CidrMask float64
+}
+
+// subnetTypes maps the subnet group types of config.json to the CDK subnet types
+var subnetTypes = map[string]awsec2.SubnetType{
+	"public":              awsec2.SubnetType_PUBLIC,
+	"private-with-egress": awsec2.SubnetType_PRIVATE_WITH_EGRESS,
+	"isolated":            awsec2.SubnetType_PRIVATE_ISOLATED,
 }
 
 func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configuration) {
@@ -57,85 +113,556 @@ func GetConfig(configcrd ConfAuth, configjs Configuration) (ConfAuth, Configurat
 	return configcrd, configjs
 }
 
-func NewVpc3Stack(scope constructs.Construct, id string, props *Vpc3StackProps, AppConfig Configuration, AppConfig1 ConfAuth) awscdk.Stack {
+// newVpc creates the VPC with the subnet groups and the NAT strategy of the configuration :
+// without Subnets, CDK default layout (one public and one private subnet per AZ),
+// without NatGateways, one NAT per AZ, with NatInstance, NAT instances instead of NAT gateways
+func newVpc(stack awscdk.Stack, AppConfig Configuration, vpcName string) (awsec2.Vpc, error) {
+	props := &awsec2.VpcProps{
+		IpAddresses: awsec2.IpAddresses_Cidr(&AppConfig.Vpccidr),
+		MaxAzs:      &AppConfig.Za,
+		VpcName:     &vpcName,
+		NatGateways: AppConfig.NatGateways,
+	}
 
-	var sprops awscdk.StackProps
-	if props != nil {
-		sprops = props.StackProps
+	if len(AppConfig.Subnets) > 0 {
+		var subnetConfiguration []*awsec2.SubnetConfiguration
+		for _, group := range AppConfig.Subnets {
+			subnetType, ok := subnetTypes[group.Type]
+			if !ok {
+				return nil, fmt.Errorf("subnet group %s : unknown type %q (public, private-with-egress or isolated)", group.Name, group.Type)
+			}
+			if group.CidrMask < 16 || group.CidrMask > 28 {
+				return nil, fmt.Errorf("subnet group %s : CidrMask %v must be between 16 and 28", group.Name, group.CidrMask)
+			}
+			subnetConfiguration = append(subnetConfiguration, &awsec2.SubnetConfiguration{
+				Name:       jsii.String(group.Name),
+				SubnetType: subnetType,
+				CidrMask:   jsii.Number(group.CidrMask),
+			})
+		}
+		props.SubnetConfiguration = &subnetConfiguration
 	}
-	stack := awscdk.NewStack(scope, &id, &sprops)
-	var vpcName = AppConfig.VpcName + AppConfig1.Index
-	var SGName = AppConfig.SgName + AppConfig1.Index
 
-	// Open AWS session
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: &AppConfig1.Region,
-	}))
+	if AppConfig.NatInstance != "" {
+		props.NatGatewayProvider = awsec2.NatProvider_Instance(&awsec2.NatInstanceProps{
+			InstanceType: awsec2.NewInstanceType(&AppConfig.NatInstance),
+		})
+	}
+
+	vpc := awsec2.NewVpc(stack, &vpcName, props)
+	if AppConfig.IPv6 {
+		dualStack(stack, vpc)
+	}
+	return vpc, nil
+}
+
+// dualStack adds an Amazon-provided IPv6 /56 to the VPC and a /64 to each subnet, with the
+// IPv6 default routes : internet gateway for the public subnets, egress-only internet gateway
+// for the private subnets, no route for the isolated subnets
+func dualStack(stack awscdk.Stack, vpc awsec2.Vpc) {
+	ipv6Block := awsec2.NewCfnVPCCidrBlock(stack, jsii.String("Ipv6Cidr"), &awsec2.CfnVPCCidrBlockProps{
+		VpcId:                       vpc.VpcId(),
+		AmazonProvidedIpv6CidrBlock: jsii.Bool(true),
+	})
+
+	var subnets []awsec2.ISubnet
+	subnets = append(subnets, *vpc.PublicSubnets()...)
+	subnets = append(subnets, *vpc.PrivateSubnets()...)
+	subnets = append(subnets, *vpc.IsolatedSubnets()...)
+
+	subnetIpv6Cidrs := awscdk.Fn_Cidr(awscdk.Fn_Select(jsii.Number(0), vpc.VpcIpv6CidrBlocks()), jsii.Number(float64(len(subnets))), jsii.String("64"))
+	for i, subnet := range subnets {
+		cfnSubnet := subnet.Node().DefaultChild().(awsec2.CfnSubnet)
+		cfnSubnet.SetIpv6CidrBlock(awscdk.Fn_Select(jsii.Number(float64(i)), subnetIpv6Cidrs))
+		cfnSubnet.SetAssignIpv6AddressOnCreation(jsii.Bool(true))
+		cfnSubnet.AddDependency(ipv6Block)
+	}
 
+	for i, subnet := range *vpc.PublicSubnets() {
+		awsec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("PublicIpv6Route%d", i+1)), &awsec2.CfnRouteProps{
+			RouteTableId:             subnet.RouteTable().RouteTableId(),
+			DestinationIpv6CidrBlock: jsii.String("::/0"),
+			GatewayId:                vpc.InternetGatewayId(),
+		})
+	}
+
+	if len(*vpc.PrivateSubnets()) > 0 {
+		egressOnlyIgw := awsec2.NewCfnEgressOnlyInternetGateway(stack, jsii.String("EgressOnlyIgw"), &awsec2.CfnEgressOnlyInternetGatewayProps{
+			VpcId: vpc.VpcId(),
+		})
+		for i, subnet := range *vpc.PrivateSubnets() {
+			awsec2.NewCfnRoute(stack, jsii.String(fmt.Sprintf("PrivateIpv6Route%d", i+1)), &awsec2.CfnRouteProps{
+				RouteTableId:                subnet.RouteTable().RouteTableId(),
+				DestinationIpv6CidrBlock:    jsii.String("::/0"),
+				EgressOnlyInternetGatewayId: egressOnlyIgw.AttrId(),
+			})
+		}
+	}
+}
+
+// interfaceEndpoints maps the endpoint names of config.json to the AWS services reached
+// with an interface endpoint (s3 is a gateway endpoint)
+var interfaceEndpoints = map[string]awsec2.InterfaceVpcEndpointAwsService{
+	"ecr":            awsec2.InterfaceVpcEndpointAwsService_ECR(),
+	"ecr.dkr":        awsec2.InterfaceVpcEndpointAwsService_ECR_DOCKER(),
+	"sts":            awsec2.InterfaceVpcEndpointAwsService_STS(),
+	"secretsmanager": awsec2.InterfaceVpcEndpointAwsService_SECRETS_MANAGER(),
+	"logs":           awsec2.InterfaceVpcEndpointAwsService_CLOUDWATCH_LOGS(),
+	"eks":            awsec2.InterfaceVpcEndpointAwsService_EKS(),
+}
+
+// addEndpoints adds the VPC endpoints of the configuration : a gateway endpoint for s3,
+// interface endpoints in the private subnets with a dedicated security group (HTTPS from the VPC)
+// for the others, so that nodes in isolated subnets reach AWS services without NAT
+func addEndpoints(stack awscdk.Stack, vpc awsec2.IVpc, AppConfig Configuration, vpcName string) error {
+	if len(AppConfig.Endpoints) == 0 {
+		return nil
+	}
+
+	var endpointSG awsec2.SecurityGroup
+	for _, name := range AppConfig.Endpoints {
+		if name == "s3" {
+			vpc.AddGatewayEndpoint(jsii.String("S3Endpoint"), &awsec2.GatewayVpcEndpointOptions{
+				Service: awsec2.GatewayVpcEndpointAwsService_S3(),
+			})
+			continue
+		}
+
+		service, ok := interfaceEndpoints[name]
+		if !ok {
+			return fmt.Errorf("unknown endpoint %q (s3, ecr, ecr.dkr, sts, secretsmanager, logs or eks)", name)
+		}
+		if endpointSG == nil {
+			endpointSG = awsec2.NewSecurityGroup(stack, jsii.String("EndpointsSG"), &awsec2.SecurityGroupProps{
+				Vpc:               vpc,
+				SecurityGroupName: jsii.String(vpcName + "-endpoints"),
+				Description:       jsii.String("VPC interface endpoints of " + vpcName),
+				AllowAllOutbound:  jsii.Bool(false),
+			})
+			endpointSG.AddIngressRule(awsec2.Peer_Ipv4(vpc.VpcCidrBlock()), awsec2.Port_Tcp(jsii.Number(443)), jsii.String("HTTPS from the VPC"), jsii.Bool(false))
+		}
+		vpc.AddInterfaceEndpoint(jsii.String(name+"Endpoint"), &awsec2.InterfaceVpcEndpointOptions{
+			Service:           service,
+			Open:              jsii.Bool(false),
+			PrivateDnsEnabled: jsii.Bool(true),
+			SecurityGroups:    &[]awsec2.ISecurityGroup{endpointSG},
+		})
+	}
+	return nil
+}
+
+// addFlowLogs adds the flow logs of the VPC to a CloudWatch Logs group or an S3 bucket, with
+// a CloudWatch Logs Insights query of the rejected traffic for the CloudWatch Logs destination
+func addFlowLogs(stack awscdk.Stack, vpc awsec2.IVpc, AppConfig Configuration, vpcName string) error {
+	config := AppConfig.FlowLogs
+	if config.Destination == "" {
+		return nil
+	}
+
+	trafficType := awsec2.FlowLogTrafficType_ALL
+	switch config.TrafficType {
+	case "", "ALL":
+	case "ACCEPT":
+		trafficType = awsec2.FlowLogTrafficType_ACCEPT
+	case "REJECT":
+		trafficType = awsec2.FlowLogTrafficType_REJECT
+	default:
+		return fmt.Errorf("unknown flow logs TrafficType %q : ALL, ACCEPT or REJECT", config.TrafficType)
+	}
+
+	var logFormat *[]awsec2.LogFormat
+	if len(config.Format) > 0 {
+		var fields []awsec2.LogFormat
+		for _, field := range config.Format {
+			fields = append(fields, awsec2.LogFormat_Field(jsii.String(field)))
+		}
+		logFormat = &fields
+	}
+
+	var destination awsec2.FlowLogDestination
+	var logGroup awslogs.LogGroup
+	switch config.Destination {
+	case "cloudwatch":
+		logGroup = awslogs.NewLogGroup(stack, jsii.String("FlowLogsGroup"), &awslogs.LogGroupProps{
+			LogGroupName:  jsii.String("/aws/vpc/flowlogs/" + vpcName),
+			Retention:     awslogs.RetentionDays_INFINITE,
+			RemovalPolicy: awscdk.RemovalPolicy_DESTROY,
+		})
+		if config.RetentionDays > 0 {
+			valid := false
+			for _, days := range logRetentionDays {
+				valid = valid || days == config.RetentionDays
+			}
+			if !valid {
+				return fmt.Errorf("flow logs RetentionDays %v is not accepted by CloudWatch Logs (%v)", config.RetentionDays, logRetentionDays)
+			}
+			logGroup.Node().DefaultChild().(awslogs.CfnLogGroup).SetRetentionInDays(jsii.Number(config.RetentionDays))
+		}
+		destination = awsec2.FlowLogDestination_ToCloudWatchLogs(logGroup, nil)
+	case "s3":
+		var lifecycleRules *[]*awss3.LifecycleRule
+		if config.RetentionDays > 0 {
+			lifecycleRules = &[]*awss3.LifecycleRule{{
+				Expiration: awscdk.Duration_Days(jsii.Number(config.RetentionDays)),
+			}}
+		}
+		bucket := awss3.NewBucket(stack, jsii.String("FlowLogsBucket"), &awss3.BucketProps{
+			Encryption:        awss3.BucketEncryption_S3_MANAGED,
+			BlockPublicAccess: awss3.BlockPublicAccess_BLOCK_ALL(),
+			EnforceSSL:        jsii.Bool(true),
+			LifecycleRules:    lifecycleRules,
+		})
+		destination = awsec2.FlowLogDestination_ToS3(bucket, nil, nil)
+	default:
+		return fmt.Errorf("unknown flow logs Destination %q : cloudwatch or s3", config.Destination)
+	}
+
+	awsec2.NewFlowLog(stack, jsii.String("FlowLogs"), &awsec2.FlowLogProps{
+		FlowLogName:  jsii.String(vpcName),
+		ResourceType: awsec2.FlowLogResourceType_FromVpc(vpc),
+		Destination:  destination,
+		TrafficType:  trafficType,
+		LogFormat:    logFormat,
+	})
+
+	// Top rejected flows, the fields are discovered by Logs Insights in the flow log records
+	if logGroup != nil {
+		awslogs.NewQueryDefinition(stack, jsii.String("RejectedTrafficQuery"), &awslogs.QueryDefinitionProps{
+			QueryDefinitionName: jsii.String(vpcName + "/RejectedTraffic"),
+			QueryString: awslogs.NewQueryString(&awslogs.QueryStringProps{
+				Fields: &[]*string{jsii.String("@timestamp"), jsii.String("srcAddr"), jsii.String("dstAddr"), jsii.String("dstPort"), jsii.String("protocol")},
+				Filter: jsii.String("action = 'REJECT'"),
+				Stats:  jsii.String("count(*) as rejected by srcAddr, dstAddr, dstPort, protocol"),
+				Sort:   jsii.String("rejected desc"),
+				Limit:  jsii.Number(50),
+			}),
+			LogGroups: &[]awslogs.ILogGroup{logGroup},
+		})
+	}
+	return nil
+}
+
+// tagSubnets adds the tags needed by EKS to place the load balancers :
+// public subnets for internet-facing, private subnets for internal
+func tagSubnets(vpc awsec2.Vpc) {
 	tagProps := &awscdk.TagProps{
 		ApplyToLaunchedInstances: jsii.Bool(false),
 		Priority:                 jsii.Number(123),
 	}
 
-	// Get VPCID by VPCName for testing if VPC exist
-	svc1 := ec2.New(sess)
+	for _, subnet := range *vpc.PublicSubnets() {
+		awscdk.Tags_Of(subnet).Add(jsii.String("kubernetes.io/role/elb"), jsii.String("1"), tagProps)
+	}
 
-	vpcimport, err := svc1.DescribeVpcs(&ec2.DescribeVpcsInput{
-		Filters: []*ec2.Filter{
-			{
-				Name:   aws.String("tag:Name"),
-				Values: []*string{&vpcName},
-			},
-		},
+	for _, subnet := range *vpc.PrivateSubnets() {
+		awscdk.Tags_Of(subnet).Add(jsii.String("kubernetes.io/role/internal-elb"), jsii.String("1"), tagProps)
+	}
+}
+
+// newSecurityGroup creates the security group of the VPC
+func newSecurityGroup(stack awscdk.Stack, vpc awsec2.IVpc, AppConfig Configuration, SGName string) awsec2.SecurityGroup {
+	securityGroup := awsec2.NewSecurityGroup(stack, &SGName, &awsec2.SecurityGroupProps{
+		Vpc:                  vpc,
+		SecurityGroupName:    &SGName,
+		Description:          &AppConfig.SgDescription,
+		AllowAllIpv6Outbound: jsii.Bool(AppConfig.IPv6),
+	})
+
+	// Add ingress and egress rules to the security group.
+	securityGroup.AddEgressRule(awsec2.Peer_AnyIpv4(), awsec2.Port_AllTraffic(), jsii.String("Allow all outbound traffic"), jsii.Bool(true))
+	return securityGroup
+}
+
+// checkImportedVpc checks the subnets of an imported VPC : the nodes of EKS run in its private
+// subnets, the lookup classifies the subnets by their aws-cdk:subnet-type tag or their routes
+func checkImportedVpc(vpc awsec2.IVpc, vpcName string) error {
+	if len(*vpc.PrivateSubnets()) == 0 {
+		return fmt.Errorf("no private subnet in the VPC %s (%s) : the EKS nodes need private subnets with a route to a NAT gateway", vpcName, *vpc.VpcId())
+	}
+	return nil
+}
+
+// tagImportedSubnets adds the tag key=1 to the subnets of an imported VPC with a custom resource
+// calling EC2 CreateTags, allowed on these subnets only. The resource always tags all the subnets
+// (CreateTags keeps the tags already there) so that it stays in the template, and the tags are
+// kept when the stack is deleted : the subnets are not managed by the stack and the load
+// balancers of EKS need them
+func tagImportedSubnets(stack awscdk.Stack, id string, subnets []awsec2.ISubnet, key string) {
+	if len(subnets) == 0 {
+		return
+	}
+	var subnetIds, subnetArns []*string
+	for _, subnet := range subnets {
+		subnetIds = append(subnetIds, subnet.SubnetId())
+		subnetArns = append(subnetArns, stack.FormatArn(&awscdk.ArnComponents{
+			Service:      jsii.String("ec2"),
+			Resource:     jsii.String("subnet"),
+			ResourceName: subnet.SubnetId(),
+		}))
+	}
+
+	createTags := &customresources.AwsSdkCall{
+		Service:            jsii.String("EC2"),
+		Action:             jsii.String("createTags"),
+		Parameters:         map[string]interface{}{"Resources": subnetIds, "Tags": []map[string]*string{{"Key": jsii.String(key), "Value": jsii.String("1")}}},
+		PhysicalResourceId: customresources.PhysicalResourceId_Of(jsii.String(id)),
+	}
+	customresources.NewAwsCustomResource(stack, &id, &customresources.AwsCustomResourceProps{
+		OnCreate: createTags,
+		OnUpdate: createTags,
+		Policy: customresources.AwsCustomResourcePolicy_FromSdkCalls(&customresources.SdkCallsPolicyOptions{
+			Resources: &subnetArns,
+		}),
 	})
+}
 
+// rulePeer returns the peer of a rule : a CIDR, a prefix list, a security group ID or
+// a security group of the configuration
+func rulePeer(peer string, groups map[string]awsec2.ISecurityGroup) (awsec2.IPeer, error) {
+	switch {
+	case groups[peer] != nil:
+		return groups[peer], nil
+	case strings.HasPrefix(peer, "pl-"):
+		return awsec2.Peer_PrefixList(jsii.String(peer)), nil
+	case strings.HasPrefix(peer, "sg-"):
+		return awsec2.Peer_SecurityGroupId(jsii.String(peer), nil), nil
+	case strings.Contains(peer, ":") && strings.Contains(peer, "/"):
+		return awsec2.Peer_Ipv6(jsii.String(peer)), nil
+	case strings.Contains(peer, "/"):
+		return awsec2.Peer_Ipv4(jsii.String(peer)), nil
+	}
+	return nil, fmt.Errorf("unknown peer %q : CIDR, prefix list, security group ID or security group Name", peer)
+}
+
+// rulePort returns the protocol and ports of a rule
+func rulePort(rule SecurityGroupRule) (awsec2.Port, error) {
+	if rule.Protocol == "all" {
+		return awsec2.Port_AllTraffic(), nil
+	}
+	if rule.Protocol == "icmp" {
+		return awsec2.Port_AllIcmp(), nil
+	}
+	if rule.Protocol != "" && rule.Protocol != "tcp" && rule.Protocol != "udp" {
+		return nil, fmt.Errorf("unknown protocol %q : tcp, udp, icmp or all", rule.Protocol)
+	}
+	udp := rule.Protocol == "udp"
+
+	if rule.Ports == "" {
+		if udp {
+			return awsec2.Port_AllUdp(), nil
+		}
+		return awsec2.Port_AllTcp(), nil
+	}
+	from, to, isRange := strings.Cut(rule.Ports, "-")
+	fromPort, err := strconv.Atoi(from)
 	if err != nil {
-		fmt.Println("❌ Error listing VPC:", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("invalid Ports %q", rule.Ports)
+	}
+	toPort := fromPort
+	if isRange {
+		if toPort, err = strconv.Atoi(to); err != nil || toPort < fromPort {
+			return nil, fmt.Errorf("invalid Ports %q", rule.Ports)
+		}
 	}
+	switch {
+	case udp && isRange:
+		return awsec2.Port_UdpRange(jsii.Number(float64(fromPort)), jsii.Number(float64(toPort))), nil
+	case udp:
+		return awsec2.Port_Udp(jsii.Number(float64(fromPort))), nil
+	case isRange:
+		return awsec2.Port_TcpRange(jsii.Number(float64(fromPort)), jsii.Number(float64(toPort))), nil
+	}
+	return awsec2.Port_Tcp(jsii.Number(float64(fromPort))), nil
+}
 
-	size := len(vpcimport.Vpcs)
+// newSecurityGroups creates the security groups of the configuration, named vpcName-Name,
+// then their rules (a rule can reference any security group of the configuration)
+func newSecurityGroups(stack awscdk.Stack, vpc awsec2.IVpc, AppConfig Configuration, vpcName string) (map[string]awsec2.ISecurityGroup, error) {
+	groups := make(map[string]awsec2.ISecurityGroup)
+	for _, config := range AppConfig.SecurityGroups {
+		if groups[config.Name] != nil {
+			return nil, fmt.Errorf("duplicate security group %q", config.Name)
+		}
+		allowAllOutbound := config.AllowAllOutbound == nil || *config.AllowAllOutbound
+		if allowAllOutbound && len(config.Egress) > 0 {
+			return nil, fmt.Errorf("security group %s : Egress rules need AllowAllOutbound false", config.Name)
+		}
+		groups[config.Name] = awsec2.NewSecurityGroup(stack, jsii.String("SG-"+config.Name), &awsec2.SecurityGroupProps{
+			Vpc:                  vpc,
+			SecurityGroupName:    jsii.String(vpcName + "-" + config.Name),
+			Description:          jsii.String(config.Description),
+			AllowAllOutbound:     jsii.Bool(allowAllOutbound),
+			AllowAllIpv6Outbound: jsii.Bool(allowAllOutbound && AppConfig.IPv6),
+		})
+	}
 
-	if size == 0 {
+	for _, config := range AppConfig.SecurityGroups {
+		group := groups[config.Name]
+		for _, rule := range config.Ingress {
+			peer, err := rulePeer(rule.Peer, groups)
+			if err != nil {
+				return nil, fmt.Errorf("security group %s : %v", config.Name, err)
+			}
+			port, err := rulePort(rule)
+			if err != nil {
+				return nil, fmt.Errorf("security group %s : %v", config.Name, err)
+			}
+			group.AddIngressRule(peer, port, jsii.String(rule.Description), jsii.Bool(false))
+		}
+		for _, rule := range config.Egress {
+			peer, err := rulePeer(rule.Peer, groups)
+			if err != nil {
+				return nil, fmt.Errorf("security group %s : %v", config.Name, err)
+			}
+			port, err := rulePort(rule)
+			if err != nil {
+				return nil, fmt.Errorf("security group %s : %v", config.Name, err)
+			}
+			group.AddEgressRule(peer, port, jsii.String(rule.Description), jsii.Bool(false))
+		}
+	}
+	return groups, nil
+}
+
+// vpcParameterPrefix is the SSM path of the VPC parameters by Index, read by the EKS stack
+func vpcParameterPrefix(index string) string {
+	return "/aws-cicd/" + index + "/vpc/"
+}
+
+// vpcOutputs outputs the same values for a created or an imported VPC and writes them to
+// SSM parameters under vpcParameterPrefix, for the next stacks
+func vpcOutputs(stack awscdk.Stack, vpc awsec2.IVpc, securityGroup awsec2.ISecurityGroup, groups map[string]awsec2.ISecurityGroup, mode string, index string) {
+	output := func(name string, parameter string, description string, value *string) {
+		awscdk.NewCfnOutput(stack, &name, &awscdk.CfnOutputProps{
+			Description: &description,
+			Value:       value,
+		})
+		awsssm.NewStringParameter(stack, jsii.String(name+"Parameter"), &awsssm.StringParameterProps{
+			ParameterName: jsii.String(vpcParameterPrefix(index) + parameter),
+			Description:   &description,
+			StringValue:   value,
+		})
+	}
+	// Outputs and parameters can't be empty : a subnet type without subnets has none
+	subnetIds := func(name string, parameter string, description string, subnets *[]awsec2.ISubnet) {
+		var ids []*string
+		for _, subnet := range *subnets {
+			ids = append(ids, subnet.SubnetId())
+		}
+		if len(ids) > 0 {
+			output(name, parameter, description, awscdk.Fn_Join(jsii.String(","), &ids))
+		}
+	}
+
+	output("VpcId", "id", "The VPC "+mode, vpc.VpcId())
+	output("SecurityGroupId", "security-group-id", "The Security Group of the VPC", securityGroup.SecurityGroupId())
+	subnetIds("PublicSubnetIds", "public-subnet-ids", "The public subnets", vpc.PublicSubnets())
+	subnetIds("PrivateSubnetIds", "private-subnet-ids", "The private subnets", vpc.PrivateSubnets())
+	subnetIds("IsolatedSubnetIds", "isolated-subnet-ids", "The isolated subnets", vpc.IsolatedSubnets())
+
+	// Security groups of the configuration : security-groups/<Name>
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		output("SecurityGroup"+name, "security-groups/"+name, "The Security Group "+name, groups[name].SecurityGroupId())
+	}
+}
+
+// NewVpc3Stack creates the VPC, or imports it with ImportVpc. The lookups of an imported VPC
+// and security group use the CDK context providers (cached in cdk.context.json)
+func NewVpc3Stack(scope constructs.Construct, id string, props *Vpc3StackProps, AppConfig Configuration, AppConfig1 ConfAuth) awscdk.Stack {
+
+	var sprops awscdk.StackProps
+	if props != nil {
+		sprops = props.StackProps
+	}
+	stack := awscdk.NewStack(scope, &id, &sprops)
+	var vpcName = AppConfig.VpcName + AppConfig1.Index
+	var SGName = AppConfig.SgName + AppConfig1.Index
+
+	if !AppConfig.ImportVpc {
 		// Create a new VPC
 		// Define the VPC with IPv4 CIDR block.
-		vpc := awsec2.NewVpc(stack, &vpcName, &awsec2.VpcProps{
-			IpAddresses: awsec2.IpAddresses_Cidr(&AppConfig.Vpccidr),
-			MaxAzs:      &AppConfig.Za,
-			VpcName:     &vpcName,
-		})
+		vpc, err := newVpc(stack, AppConfig, vpcName)
+		if err != nil {
+			fmt.Println("❌ Error in the VPC configuration:", err)
+			os.Exit(1)
+		}
 
 		// Create a security group within the VPC.
-		securityGroup := awsec2.NewSecurityGroup(stack, &SGName, &awsec2.SecurityGroupProps{
-			Vpc:               vpc,
-			SecurityGroupName: &SGName,
-			Description:       &AppConfig.SgDescription,
-		})
-
+		securityGroup := newSecurityGroup(stack, vpc, AppConfig, SGName)
 		securityGroup.Node().AddDependency(vpc)
-		// Add ingress and egress rules to the security group.
-		securityGroup.AddEgressRule(awsec2.Peer_AnyIpv4(), awsec2.Port_AllTraffic(), jsii.String("Allow all outbound traffic"), jsii.Bool(true))
 
 		// Tags Subnets for  to be used by EKS
+		tagSubnets(vpc)
 
-		for _, subnet := range *vpc.PublicSubnets() {
-			awscdk.Tags_Of(subnet).Add(jsii.String("kubernetes.io/role/elb"), jsii.String("1"), tagProps)
+		// VPC endpoints for ECR, S3, STS, Secrets Manager, CloudWatch Logs and EKS
+		if err := addEndpoints(stack, vpc, AppConfig, vpcName); err != nil {
+			fmt.Println("❌ Error in the VPC endpoints configuration:", err)
+			os.Exit(1)
 		}
 
-		for _, subnet := range *vpc.PrivateSubnets() {
-			awscdk.Tags_Of(subnet).Add(jsii.String("kubernetes.io/role/internal-elb"), jsii.String("1"), tagProps)
+		// Flow logs to CloudWatch Logs or S3
+		if err := addFlowLogs(stack, vpc, AppConfig, vpcName); err != nil {
+			fmt.Println("❌ Error in the VPC flow logs configuration:", err)
+			os.Exit(1)
 		}
 
-		awscdk.NewCfnOutput(stack, aws.String("VPC_CREATED"), &awscdk.CfnOutputProps{
-			Description: aws.String("The VPC Created"),
-			Value:       vpc.VpcId(),
-		})
+		// Security groups of the configuration
+		groups, err := newSecurityGroups(stack, vpc, AppConfig, vpcName)
+		if err != nil {
+			fmt.Println("❌ Error in the security groups configuration:", err)
+			os.Exit(1)
+		}
+
+		vpcOutputs(stack, vpc, securityGroup, groups, "created", AppConfig1.Index)
 
 	} else {
-		awscdk.NewCfnOutput(stack, aws.String("VPC_EXIST"), &awscdk.CfnOutputProps{
-			Description: aws.String("The VPC already exists"),
-			Value:       vpcimport.Vpcs[0].VpcId,
+		// Import the existing VPC with its subnets
+		vpc := awsec2.Vpc_FromLookup(stack, jsii.String("ImportedVpc"), &awsec2.VpcLookupOptions{
+			VpcName: &vpcName,
 		})
+		if err := checkImportedVpc(vpc, vpcName); err != nil {
+			fmt.Println("❌ Error in the imported VPC:", err)
+			os.Exit(1)
+		}
+
+		// Look up the security group, or create it in the imported VPC
+		var securityGroup awsec2.ISecurityGroup
+		if AppConfig.ImportSecurityGroup {
+			securityGroup = awsec2.SecurityGroup_FromLookupByName(stack, &SGName, &SGName, vpc)
+		} else {
+			securityGroup = newSecurityGroup(stack, vpc, AppConfig, SGName)
+		}
+
+		// Tags Subnets for  to be used by EKS : the subnets are not managed by the stack,
+		// the tags are added with a custom resource
+		tagImportedSubnets(stack, "ElbTags", *vpc.PublicSubnets(), "kubernetes.io/role/elb")
+		tagImportedSubnets(stack, "InternalElbTags", *vpc.PrivateSubnets(), "kubernetes.io/role/internal-elb")
+
+		// VPC endpoints for ECR, S3, STS, Secrets Manager, CloudWatch Logs and EKS
+		if err := addEndpoints(stack, vpc, AppConfig, vpcName); err != nil {
+			fmt.Println("❌ Error in the VPC endpoints configuration:", err)
+			os.Exit(1)
+		}
+
+		// Flow logs to CloudWatch Logs or S3
+		if err := addFlowLogs(stack, vpc, AppConfig, vpcName); err != nil {
+			fmt.Println("❌ Error in the VPC flow logs configuration:", err)
+			os.Exit(1)
+		}
+
+		// Security groups of the configuration
+		groups, err := newSecurityGroups(stack, vpc, AppConfig, vpcName)
+		if err != nil {
+			fmt.Println("❌ Error in the security groups configuration:", err)
+			os.Exit(1)
+		}
+
+		vpcOutputs(stack, vpc, securityGroup, groups, "imported", AppConfig1.Index)
 	}
 
 	return stack
@@ -1,26 +1,604 @@
 package main
 
-// import (
-// 	"testing"
-
-// 	"github.com/aws/aws-cdk-go/awscdk/v2"
-// 	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
-// 	"github.com/aws/jsii-runtime-go"
-// )
-
-// example tests. To run these tests, uncomment this file along with the
-// example resource in vpc3_test.go
-// func TestVpc3Stack(t *testing.T) {
-// 	// GIVEN
-// 	app := awscdk.NewApp(nil)
-
-// 	// WHEN
-// 	stack := NewVpc3Stack(app, "MyStack", nil)
-
-// 	// THEN
-// 	template := assertions.Template_FromStack(stack)
-
-// 	template.HasResourceProperties(jsii.String("AWS::SQS::Queue"), map[string]interface{}{
-// 		"VisibilityTimeout": 300,
-// 	})
-// }
+import (
+	"testing"
+
+	"github.com/aws/aws-cdk-go/awscdk/v2"
+	"github.com/aws/aws-cdk-go/awscdk/v2/assertions"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsec2"
+	"github.com/aws/jsii-runtime-go"
+)
+
+// testStack synthesizes a VPC built from AppConfig in a stack with a fixed environment
+// (the AZs and the NAT instance AMI are resolved with dummy context values)
+func testStack(t *testing.T, AppConfig Configuration) assertions.Template {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("TestStack"), &awscdk.StackProps{
+		Env: env("eu-west-1", "123456789012"),
+	})
+
+	vpc, err := newVpc(stack, AppConfig, AppConfig.VpcName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagSubnets(vpc)
+	if err := addEndpoints(stack, vpc, AppConfig, AppConfig.VpcName); err != nil {
+		t.Fatal(err)
+	}
+	if err := addFlowLogs(stack, vpc, AppConfig, AppConfig.VpcName); err != nil {
+		t.Fatal(err)
+	}
+
+	return assertions.Template_FromStack(stack, nil)
+}
+
+func testConfig() Configuration {
+	return Configuration{
+		VpcName: "TestVpc",
+		Vpccidr: "10.0.0.0/16",
+		Za:      2,
+	}
+}
+
+func TestVpcDefaultLayout(t *testing.T) {
+	template := testStack(t, testConfig())
+
+	template.ResourceCountIs(jsii.String("AWS::EC2::Subnet"), jsii.Number(4))
+	template.ResourceCountIs(jsii.String("AWS::EC2::NatGateway"), jsii.Number(2))
+}
+
+func TestVpcSubnetGroups(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.Subnets = []SubnetGroup{
+		{Name: "public", Type: "public", CidrMask: 24},
+		{Name: "nodes", Type: "private-with-egress", CidrMask: 20},
+		{Name: "data", Type: "isolated", CidrMask: 28},
+	}
+	AppConfig.NatGateways = jsii.Number(1)
+	template := testStack(t, AppConfig)
+
+	template.ResourceCountIs(jsii.String("AWS::EC2::Subnet"), jsii.Number(6))
+	template.ResourceCountIs(jsii.String("AWS::EC2::NatGateway"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("AWS::EC2::Subnet"), map[string]interface{}{
+		"CidrBlock":           "10.0.0.0/24",
+		"MapPublicIpOnLaunch": true,
+		"Tags": assertions.Match_ArrayWith(&[]interface{}{
+			map[string]interface{}{"Key": "kubernetes.io/role/elb", "Value": "1"},
+		}),
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::Subnet"), map[string]interface{}{
+		"CidrBlock": "10.0.16.0/20",
+		"Tags": assertions.Match_ArrayWith(&[]interface{}{
+			map[string]interface{}{"Key": "kubernetes.io/role/internal-elb", "Value": "1"},
+		}),
+	})
+	// Isolated subnets are not used by the load balancers
+	template.HasResourceProperties(jsii.String("AWS::EC2::Subnet"), map[string]interface{}{
+		"CidrBlock": "10.0.48.0/28",
+		"Tags": assertions.Match_Not(assertions.Match_ArrayWith(&[]interface{}{
+			assertions.Match_ObjectLike(&map[string]interface{}{"Key": "kubernetes.io/role/internal-elb"}),
+		})),
+	})
+}
+
+func TestVpcWithoutNat(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.Subnets = []SubnetGroup{
+		{Name: "public", Type: "public", CidrMask: 24},
+		{Name: "data", Type: "isolated", CidrMask: 24},
+	}
+	AppConfig.NatGateways = jsii.Number(0)
+	template := testStack(t, AppConfig)
+
+	template.ResourceCountIs(jsii.String("AWS::EC2::Subnet"), jsii.Number(4))
+	template.ResourceCountIs(jsii.String("AWS::EC2::NatGateway"), jsii.Number(0))
+	template.ResourceCountIs(jsii.String("AWS::EC2::EIP"), jsii.Number(0))
+}
+
+func TestVpcNatInstance(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.NatInstance = "t3.nano"
+	template := testStack(t, AppConfig)
+
+	template.ResourceCountIs(jsii.String("AWS::EC2::NatGateway"), jsii.Number(0))
+	template.ResourceCountIs(jsii.String("AWS::EC2::Instance"), jsii.Number(2))
+	template.HasResourceProperties(jsii.String("AWS::EC2::Instance"), map[string]interface{}{
+		"InstanceType":    "t3.nano",
+		"SourceDestCheck": false,
+	})
+}
+
+func TestVpcEndpoints(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.Subnets = []SubnetGroup{
+		{Name: "nodes", Type: "isolated", CidrMask: 20},
+	}
+	AppConfig.NatGateways = jsii.Number(0)
+	AppConfig.Endpoints = []string{"s3", "ecr", "ecr.dkr", "sts", "secretsmanager", "logs", "eks"}
+	template := testStack(t, AppConfig)
+
+	template.ResourceCountIs(jsii.String("AWS::EC2::VPCEndpoint"), jsii.Number(7))
+	template.HasResourceProperties(jsii.String("AWS::EC2::VPCEndpoint"), map[string]interface{}{
+		"VpcEndpointType": "Gateway",
+		"ServiceName":     assertions.Match_AnyValue(),
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::VPCEndpoint"), map[string]interface{}{
+		"VpcEndpointType":   "Interface",
+		"ServiceName":       "com.amazonaws.eu-west-1.ecr.dkr",
+		"PrivateDnsEnabled": true,
+	})
+	// One dedicated security group for all the interface endpoints
+	template.ResourceCountIs(jsii.String("AWS::EC2::SecurityGroup"), jsii.Number(1))
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"GroupName": "TestVpc-endpoints",
+		"SecurityGroupIngress": []interface{}{
+			assertions.Match_ObjectLike(&map[string]interface{}{
+				"IpProtocol": "tcp",
+				"FromPort":   443,
+				"ToPort":     443,
+			}),
+		},
+	})
+}
+
+func TestVpcInvalidEndpoint(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("TestStack"), nil)
+
+	AppConfig := testConfig()
+	AppConfig.Endpoints = []string{"dynamo"}
+	vpc, err := newVpc(stack, AppConfig, AppConfig.VpcName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addEndpoints(stack, vpc, AppConfig, AppConfig.VpcName); err == nil {
+		t.Fatal("expected an error for an unknown endpoint")
+	}
+}
+
+func TestVpcInvalidSubnetGroup(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("TestStack"), nil)
+
+	AppConfig := testConfig()
+	AppConfig.Subnets = []SubnetGroup{{Name: "nodes", Type: "private", CidrMask: 24}}
+	if _, err := newVpc(stack, AppConfig, AppConfig.VpcName); err == nil {
+		t.Fatal("expected an error for an unknown subnet type")
+	}
+
+	AppConfig.Subnets = []SubnetGroup{{Name: "nodes", Type: "public", CidrMask: 30}}
+	if _, err := newVpc(stack, AppConfig, AppConfig.VpcName); err == nil {
+		t.Fatal("expected an error for a CidrMask out of range")
+	}
+}
+
+func TestImportedVpc(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("TestStack"), &awscdk.StackProps{
+		Env: env("eu-west-1", "123456789012"),
+	})
+
+	// Without context the lookup returns a dummy VPC with public and private subnets
+	vpc := awsec2.Vpc_FromLookup(stack, jsii.String("ImportedVpc"), &awsec2.VpcLookupOptions{
+		VpcId: jsii.String("vpc-12345"),
+	})
+	publicSubnets := *vpc.PublicSubnets()
+	privateSubnets := *vpc.PrivateSubnets()
+
+	tagImportedSubnets(stack, "ElbTags", publicSubnets, "kubernetes.io/role/elb")
+	tagImportedSubnets(stack, "InternalElbTags", privateSubnets, "kubernetes.io/role/internal-elb")
+
+	securityGroup := newSecurityGroup(stack, vpc, testConfig(), "TestSG")
+	vpcOutputs(stack, vpc, securityGroup, nil, "imported", "01")
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	// All the subnets are tagged, on creation and on update, the tags are kept on deletion
+	template.ResourceCountIs(jsii.String("Custom::AWS"), jsii.Number(2))
+	createTags := assertions.Match_SerializedJson(assertions.Match_ObjectLike(&map[string]interface{}{
+		"action": "createTags",
+		"parameters": map[string]interface{}{
+			"Resources": []interface{}{*privateSubnets[0].SubnetId(), *privateSubnets[1].SubnetId()},
+			"Tags":      []interface{}{map[string]interface{}{"Key": "kubernetes.io/role/internal-elb", "Value": "1"}},
+		},
+	}))
+	template.HasResourceProperties(jsii.String("Custom::AWS"), map[string]interface{}{
+		"Create": createTags,
+		"Update": createTags,
+		"Delete": assertions.Match_Absent(),
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"GroupName": "TestSG",
+		"VpcId":     "vpc-12345",
+	})
+	for _, output := range []string{"VpcId", "SecurityGroupId", "PublicSubnetIds", "PrivateSubnetIds"} {
+		template.HasOutput(jsii.String(output), map[string]interface{}{})
+	}
+	template.HasResourceProperties(jsii.String("AWS::SSM::Parameter"), map[string]interface{}{
+		"Name":  "/aws-cicd/01/vpc/id",
+		"Value": "vpc-12345",
+	})
+	template.HasResourceProperties(jsii.String("AWS::SSM::Parameter"), map[string]interface{}{
+		"Name":  "/aws-cicd/01/vpc/private-subnet-ids",
+		"Value": *privateSubnets[0].SubnetId() + "," + *privateSubnets[1].SubnetId(),
+	})
+}
+
+func testVpc3Stack(AppConfig Configuration) assertions.Template {
+	app := awscdk.NewApp(nil)
+	AppConfig.SgName = "TestSG"
+	AppConfig1 := ConfAuth{Region: "eu-west-1", Account: "123456789012", Index: "01"}
+
+	stack := NewVpc3Stack(app, "VPCStack01", &Vpc3StackProps{
+		awscdk.StackProps{
+			Env: env(AppConfig1.Region, AppConfig1.Account),
+		},
+	}, AppConfig, AppConfig1)
+	return assertions.Template_FromStack(stack, nil)
+}
+
+func TestVpc3StackCreate(t *testing.T) {
+	template := testVpc3Stack(testConfig())
+
+	template.ResourceCountIs(jsii.String("AWS::EC2::VPC"), jsii.Number(1))
+	template.ResourceCountIs(jsii.String("Custom::AWS"), jsii.Number(0))
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"GroupName": "TestSG01",
+	})
+	template.HasOutput(jsii.String("VpcId"), map[string]interface{}{
+		"Description": "The VPC created",
+	})
+}
+
+// Without cdk.context.json the context providers return dummy values : vpc-12345 and sg-12345678
+func TestVpc3StackImport(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.ImportVpc = true
+	AppConfig.ImportSecurityGroup = true
+	template := testVpc3Stack(AppConfig)
+
+	template.ResourceCountIs(jsii.String("AWS::EC2::VPC"), jsii.Number(0))
+	template.ResourceCountIs(jsii.String("AWS::EC2::SecurityGroup"), jsii.Number(0))
+	// Public and private subnets are tagged by custom resources
+	template.ResourceCountIs(jsii.String("Custom::AWS"), jsii.Number(2))
+	template.HasOutput(jsii.String("VpcId"), map[string]interface{}{
+		"Description": "The VPC imported",
+		"Value":       "vpc-12345",
+	})
+	template.HasOutput(jsii.String("SecurityGroupId"), map[string]interface{}{
+		"Value": "sg-12345678",
+	})
+}
+
+func TestVpc3StackImportCreateSecurityGroup(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.ImportVpc = true
+	template := testVpc3Stack(AppConfig)
+
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"GroupName": "TestSG01",
+		"VpcId":     "vpc-12345",
+	})
+	template.ResourceCountIs(jsii.String("Custom::AWS"), jsii.Number(2))
+}
+
+// The lookups are answered from the context (cdk.context.json) : no AWS call during the synth
+func TestVpc3StackImportFromContext(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.ImportVpc = true
+	AppConfig.ImportSecurityGroup = true
+	app := awscdk.NewApp(&awscdk.AppProps{
+		Context: &map[string]interface{}{
+			"vpc-provider:account=123456789012:filter.tag:Name=TestVpc01:region=eu-west-1:returnAsymmetricSubnets=true": map[string]interface{}{
+				"vpcId":             "vpc-0a1b2c3d",
+				"vpcCidrBlock":      "192.168.0.0/16",
+				"availabilityZones": []interface{}{},
+				"subnetGroups": []interface{}{
+					map[string]interface{}{
+						"name": "Public",
+						"type": "Public",
+						"subnets": []interface{}{
+							map[string]interface{}{"subnetId": "subnet-pub1", "cidr": "192.168.0.0/24", "availabilityZone": "eu-west-1a", "routeTableId": "rtb-1"},
+						},
+					},
+					map[string]interface{}{
+						"name": "Private",
+						"type": "Private",
+						"subnets": []interface{}{
+							map[string]interface{}{"subnetId": "subnet-priv1", "cidr": "192.168.32.0/19", "availabilityZone": "eu-west-1a", "routeTableId": "rtb-2"},
+						},
+					},
+				},
+			},
+			"security-group:account=123456789012:region=eu-west-1:securityGroupName=TestSG01:vpcId=vpc-0a1b2c3d": map[string]interface{}{
+				"securityGroupId":  "sg-0a1b2c3d",
+				"allowAllOutbound": true,
+			},
+		},
+	})
+	AppConfig.SgName = "TestSG"
+	stack := NewVpc3Stack(app, "VPCStack01", &Vpc3StackProps{
+		awscdk.StackProps{
+			Env: env("eu-west-1", "123456789012"),
+		},
+	}, AppConfig, ConfAuth{Region: "eu-west-1", Account: "123456789012", Index: "01"})
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.HasOutput(jsii.String("VpcId"), map[string]interface{}{"Value": "vpc-0a1b2c3d"})
+	template.HasOutput(jsii.String("SecurityGroupId"), map[string]interface{}{"Value": "sg-0a1b2c3d"})
+	template.HasOutput(jsii.String("PrivateSubnetIds"), map[string]interface{}{"Value": "subnet-priv1"})
+	template.HasResourceProperties(jsii.String("Custom::AWS"), map[string]interface{}{
+		"Create": assertions.Match_SerializedJson(assertions.Match_ObjectLike(&map[string]interface{}{
+			"parameters": map[string]interface{}{
+				"Resources": []interface{}{"subnet-pub1"},
+				"Tags":      []interface{}{map[string]interface{}{"Key": "kubernetes.io/role/elb", "Value": "1"}},
+			},
+		})),
+	})
+	// CreateTags is only allowed on the subnets of the VPC
+	template.HasResourceProperties(jsii.String("AWS::IAM::Policy"), map[string]interface{}{
+		"PolicyDocument": map[string]interface{}{
+			"Statement": []interface{}{map[string]interface{}{
+				"Action": "ec2:CreateTags",
+				"Effect": "Allow",
+				"Resource": map[string]interface{}{
+					"Fn::Join": []interface{}{"", []interface{}{"arn:", map[string]interface{}{"Ref": "AWS::Partition"}, ":ec2:eu-west-1:123456789012:subnet/subnet-priv1"}},
+				},
+			}},
+		},
+	})
+}
+
+func TestCheckImportedVpc(t *testing.T) {
+	subnetGroup := func(name string, subnetId string) map[string]interface{} {
+		return map[string]interface{}{
+			"name": name,
+			"type": name,
+			"subnets": []interface{}{
+				map[string]interface{}{"subnetId": subnetId, "cidr": "192.168.0.0/24", "availabilityZone": "eu-west-1a", "routeTableId": "rtb-1"},
+			},
+		}
+	}
+	for _, test := range []struct {
+		subnetGroups []interface{}
+		valid        bool
+	}{
+		{[]interface{}{subnetGroup("Public", "subnet-pub1"), subnetGroup("Private", "subnet-priv1")}, true},
+		{[]interface{}{subnetGroup("Public", "subnet-pub1")}, false},
+		{[]interface{}{subnetGroup("Public", "subnet-pub1"), subnetGroup("Isolated", "subnet-iso1")}, false},
+	} {
+		app := awscdk.NewApp(&awscdk.AppProps{
+			Context: &map[string]interface{}{
+				"vpc-provider:account=123456789012:filter.tag:Name=TestVpc01:region=eu-west-1:returnAsymmetricSubnets=true": map[string]interface{}{
+					"vpcId":             "vpc-0a1b2c3d",
+					"vpcCidrBlock":      "192.168.0.0/16",
+					"availabilityZones": []interface{}{},
+					"subnetGroups":      test.subnetGroups,
+				},
+			},
+		})
+		stack := awscdk.NewStack(app, jsii.String("TestStack"), &awscdk.StackProps{
+			Env: env("eu-west-1", "123456789012"),
+		})
+		vpc := awsec2.Vpc_FromLookup(stack, jsii.String("ImportedVpc"), &awsec2.VpcLookupOptions{
+			VpcName: jsii.String("TestVpc01"),
+		})
+		if err := checkImportedVpc(vpc, "TestVpc01"); (err == nil) != test.valid {
+			t.Errorf("%d subnet groups : %v", len(test.subnetGroups), err)
+		}
+	}
+}
+
+func TestVpcDualStack(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.Subnets = []SubnetGroup{
+		{Name: "public", Type: "public", CidrMask: 24},
+		{Name: "nodes", Type: "private-with-egress", CidrMask: 20},
+		{Name: "data", Type: "isolated", CidrMask: 24},
+	}
+	AppConfig.IPv6 = true
+	template := testStack(t, AppConfig)
+
+	template.HasResourceProperties(jsii.String("AWS::EC2::VPCCidrBlock"), map[string]interface{}{
+		"AmazonProvidedIpv6CidrBlock": true,
+	})
+	template.ResourceCountIs(jsii.String("AWS::EC2::EgressOnlyInternetGateway"), jsii.Number(1))
+	// Every subnet has an IPv6 /64
+	template.AllResourcesProperties(jsii.String("AWS::EC2::Subnet"), map[string]interface{}{
+		"AssignIpv6AddressOnCreation": true,
+		"Ipv6CidrBlock":               assertions.Match_ObjectLike(&map[string]interface{}{"Fn::Select": assertions.Match_AnyValue()}),
+	})
+	// IPv6 default routes : public and private subnets, not isolated subnets
+	template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::Route"), map[string]interface{}{
+		"DestinationIpv6CidrBlock": "::/0",
+		"GatewayId":                assertions.Match_AnyValue(),
+	}, jsii.Number(2))
+	template.ResourcePropertiesCountIs(jsii.String("AWS::EC2::Route"), map[string]interface{}{
+		"DestinationIpv6CidrBlock":    "::/0",
+		"EgressOnlyInternetGatewayId": assertions.Match_AnyValue(),
+	}, jsii.Number(2))
+}
+
+func TestVpcWithoutFlowLogs(t *testing.T) {
+	template := testStack(t, testConfig())
+
+	template.ResourceCountIs(jsii.String("AWS::EC2::FlowLog"), jsii.Number(0))
+}
+
+func TestVpcFlowLogsCloudWatch(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.FlowLogs = FlowLogs{
+		Destination:   "cloudwatch",
+		TrafficType:   "REJECT",
+		RetentionDays: 14,
+		Format:        []string{"version", "srcaddr", "dstaddr", "dstport", "protocol", "action"},
+	}
+	template := testStack(t, AppConfig)
+
+	template.HasResourceProperties(jsii.String("AWS::Logs::LogGroup"), map[string]interface{}{
+		"LogGroupName":    "/aws/vpc/flowlogs/TestVpc",
+		"RetentionInDays": 14,
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::FlowLog"), map[string]interface{}{
+		"ResourceType":       "VPC",
+		"TrafficType":        "REJECT",
+		"LogDestinationType": "cloud-watch-logs",
+		"LogFormat":          "${version} ${srcaddr} ${dstaddr} ${dstport} ${protocol} ${action}",
+	})
+	template.HasResourceProperties(jsii.String("AWS::Logs::QueryDefinition"), map[string]interface{}{
+		"Name":        "TestVpc/RejectedTraffic",
+		"QueryString": assertions.Match_StringLikeRegexp(jsii.String("filter action = 'REJECT'")),
+	})
+}
+
+func TestVpcFlowLogsS3(t *testing.T) {
+	AppConfig := testConfig()
+	AppConfig.FlowLogs = FlowLogs{
+		Destination:   "s3",
+		RetentionDays: 90,
+	}
+	template := testStack(t, AppConfig)
+
+	template.HasResourceProperties(jsii.String("AWS::S3::Bucket"), map[string]interface{}{
+		"LifecycleConfiguration": map[string]interface{}{
+			"Rules": []interface{}{
+				assertions.Match_ObjectLike(&map[string]interface{}{"ExpirationInDays": 90}),
+			},
+		},
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::FlowLog"), map[string]interface{}{
+		"TrafficType":        "ALL",
+		"LogDestinationType": "s3",
+	})
+	// Logs Insights only queries CloudWatch Logs
+	template.ResourceCountIs(jsii.String("AWS::Logs::QueryDefinition"), jsii.Number(0))
+}
+
+func TestVpcInvalidFlowLogs(t *testing.T) {
+	for _, flowLogs := range []FlowLogs{
+		{Destination: "kinesis"},
+		{Destination: "s3", TrafficType: "DROP"},
+		{Destination: "cloudwatch", RetentionDays: 10},
+	} {
+		app := awscdk.NewApp(nil)
+		stack := awscdk.NewStack(app, jsii.String("TestStack"), nil)
+
+		AppConfig := testConfig()
+		AppConfig.FlowLogs = flowLogs
+		vpc, err := newVpc(stack, AppConfig, AppConfig.VpcName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := addFlowLogs(stack, vpc, AppConfig, AppConfig.VpcName); err == nil {
+			t.Fatalf("expected an error for the flow logs %+v", flowLogs)
+		}
+	}
+}
+
+func TestSecurityGroups(t *testing.T) {
+	app := awscdk.NewApp(nil)
+	stack := awscdk.NewStack(app, jsii.String("TestStack"), &awscdk.StackProps{
+		Env: env("eu-west-1", "123456789012"),
+	})
+
+	AppConfig := testConfig()
+	AppConfig.SecurityGroups = []SecurityGroup{
+		{
+			Name:        "nodes",
+			Description: "EKS nodes",
+			Ingress: []SecurityGroupRule{
+				{Peer: "nodes", Protocol: "all", Description: "Node to node"},
+				{Peer: "10.0.0.0/8", Ports: "30000-32767", Description: "NodePort services"},
+			},
+		},
+		{
+			Name:             "postgres",
+			Description:      "PostgreSQL database",
+			AllowAllOutbound: jsii.Bool(false),
+			Ingress: []SecurityGroupRule{
+				{Peer: "nodes", Ports: "5432", Description: "PostgreSQL from the nodes"},
+				{Peer: "pl-12345", Ports: "5432", Description: "PostgreSQL from the admin network"},
+			},
+			Egress: []SecurityGroupRule{
+				{Peer: "10.0.0.0/16", Protocol: "udp", Ports: "53", Description: "DNS"},
+			},
+		},
+	}
+	vpc, err := newVpc(stack, AppConfig, AppConfig.VpcName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := newSecurityGroups(stack, vpc, AppConfig, AppConfig.VpcName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vpcOutputs(stack, vpc, groups["nodes"], groups, "created", "01")
+
+	template := assertions.Template_FromStack(stack, nil)
+
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"GroupName": "TestVpc-nodes",
+		"SecurityGroupIngress": assertions.Match_ArrayWith(&[]interface{}{
+			map[string]interface{}{
+				"CidrIp":      "10.0.0.0/8",
+				"IpProtocol":  "tcp",
+				"FromPort":    30000,
+				"ToPort":      32767,
+				"Description": "NodePort services",
+			},
+		}),
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroup"), map[string]interface{}{
+		"GroupName": "TestVpc-postgres",
+		"SecurityGroupEgress": []interface{}{
+			map[string]interface{}{
+				"CidrIp":      "10.0.0.0/16",
+				"IpProtocol":  "udp",
+				"FromPort":    53,
+				"ToPort":      53,
+				"Description": "DNS",
+			},
+		},
+	})
+	// Rules with security groups and prefix lists are separate resources
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroupIngress"), map[string]interface{}{
+		"SourcePrefixListId": "pl-12345",
+		"FromPort":           5432,
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroupIngress"), map[string]interface{}{
+		"IpProtocol":  "tcp",
+		"FromPort":    5432,
+		"ToPort":      5432,
+		"Description": "PostgreSQL from the nodes",
+	})
+	template.HasResourceProperties(jsii.String("AWS::EC2::SecurityGroupIngress"), map[string]interface{}{
+		"IpProtocol":  "-1",
+		"Description": "Node to node",
+	})
+	template.HasResourceProperties(jsii.String("AWS::SSM::Parameter"), map[string]interface{}{
+		"Name": "/aws-cicd/01/vpc/security-groups/postgres",
+	})
+}
+
+func TestInvalidSecurityGroups(t *testing.T) {
+	for _, group := range []SecurityGroup{
+		{Name: "a", Ingress: []SecurityGroupRule{{Peer: "unknown", Ports: "443"}}},
+		{Name: "b", Ingress: []SecurityGroupRule{{Peer: "10.0.0.0/8", Ports: "https"}}},
+		{Name: "c", Ingress: []SecurityGroupRule{{Peer: "10.0.0.0/8", Ports: "443-80"}}},
+		{Name: "d", Ingress: []SecurityGroupRule{{Peer: "10.0.0.0/8", Protocol: "sctp"}}},
+		{Name: "e", Egress: []SecurityGroupRule{{Peer: "10.0.0.0/8", Ports: "443"}}},
+	} {
+		app := awscdk.NewApp(nil)
+		stack := awscdk.NewStack(app, jsii.String("TestStack"), nil)
+
+		AppConfig := testConfig()
+		AppConfig.SecurityGroups = []SecurityGroup{group}
+		vpc, err := newVpc(stack, AppConfig, AppConfig.VpcName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := newSecurityGroups(stack, vpc, AppConfig, AppConfig.VpcName); err == nil {
+			t.Fatalf("expected an error for the security group %+v", group)
+		}
+	}
+}